@@ -10,24 +10,48 @@ import (
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/micahco/api/internal/data"
 	"github.com/micahco/api/internal/mailer"
+	"github.com/micahco/api/internal/migrate"
+	"github.com/micahco/api/internal/ratelimit"
 )
 
 type application struct {
-	config config
-	logger *slog.Logger
-	mailer *mailer.Mailer
-	models data.Models
-	wg     sync.WaitGroup
+	cfg        atomic.Pointer[config]
+	loadConfig func() (config, error)
+	reloadMu   sync.Mutex
+	logger     *slog.Logger
+	logLevel   *slog.LevelVar
+	limiter    ratelimit.Store
+	mailer     *mailer.Mailer
+	migrator   *migrate.Migrator
+	models     data.Models
+	pool       *pgxpool.Pool
+	prom       *promMetrics
+	ipRules    ipRuleSnapshot
+	wg         sync.WaitGroup
+
+	// Set once graceful shutdown begins so readiness fails
+	shuttingDown atomic.Bool
+}
+
+// Current configuration, which may change when reloaded.
+func (app *application) config() config {
+	return *app.cfg.Load()
+}
+
+func (app *application) setConfig(cfg config) {
+	app.cfg.Store(&cfg)
 }
 
 func (app *application) serve(errLog *log.Logger) error {
 	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", app.config.port),
+		Addr:         fmt.Sprintf(":%d", app.config().port),
 		Handler:      app.routes(),
 		ErrorLog:     errLog,
 		IdleTimeout:  time.Minute,
@@ -35,8 +59,62 @@ func (app *application) serve(errLog *log.Logger) error {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	// Metrics and debug endpoints are served on their own listeners so
+	// they aren't public
+	var internalSrvs []*http.Server
+	if addr := app.config().metricsAddr; addr != "" && app.prom != nil {
+		metricsSrv := newInternalServer(addr, app.prom.handler(), errLog)
+		internalSrvs = append(internalSrvs, metricsSrv)
+
+		go app.serveInternal("metrics", metricsSrv)
+	}
+	if app.config().debugAddr != "" {
+		debugSrv := newInternalServer(app.config().debugListenAddr(), app.debugRoutes(), errLog)
+		// CPU profiles and traces stream for as long as requested
+		debugSrv.WriteTimeout = 0
+		internalSrvs = append(internalSrvs, debugSrv)
+
+		go app.serveInternal("debug", debugSrv)
+	}
+
+	// Remove old mail sends, idle rate limiter state and expired IP
+	// rules until shutdown
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	defer stopPurge()
+
+	go app.mailer.RunPurge(purgeCtx, time.Hour, func(err error) {
+		app.logger.Error("unable to purge mail send log", slog.Any("err", err))
+	})
+
+	if app.limiter != nil {
+		go ratelimit.RunPurge(purgeCtx, app.limiter, time.Minute, func(err error) {
+			app.logger.Error("unable to purge rate limiter", slog.Any("err", err))
+		})
+	}
+
+	// Expired bans and rules are already ignored, this keeps the table small
+	go app.purgeIPRules(purgeCtx, time.Hour)
+
+	// Pick up IP rules changed by other servers
+	go app.watchIPRules(purgeCtx, ipRuleRefreshInterval)
+
 	shutdownError := make(chan error)
 
+	// Reload configuration on SIGHUP until shutdown begins
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			app.logger.Info("reloading configuration", slog.String("signal", "hangup"))
+
+			_, err := app.reload()
+			if err != nil {
+				app.logger.Error("unable to reload configuration", slog.Any("err", err))
+			}
+		}
+	}()
+
 	go func() {
 		// Intercept signals
 		quit := make(chan os.Signal, 1)
@@ -45,14 +123,32 @@ func (app *application) serve(errLog *log.Logger) error {
 
 		app.logger.Info("shutting down server", slog.String("signal", s.String()))
 
+		// Nothing is delivered after Stop returns, so closing the
+		// channel ends the reload loop
+		signal.Stop(hup)
+		close(hup)
+
+		// Report not ready and give load balancers time to notice
+		// before connections are drained.
+		app.shuttingDown.Store(true)
+		time.Sleep(app.config().shutdownDelay)
+
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
+		// Keep serving metrics and debug endpoints until the main
+		// server has drained
+		for _, internalSrv := range internalSrvs {
+			defer internalSrv.Close()
+		}
+
 		err := srv.Shutdown(ctx)
 		if err != nil {
 			shutdownError <- err
 		}
 
+		stopPurge()
+
 		app.logger.Info("completing background tasks", slog.String("addr", srv.Addr))
 
 		// Block until WaitGroup is zero
@@ -78,20 +174,52 @@ func (app *application) serve(errLog *log.Logger) error {
 	return nil
 }
 
-func (app *application) background(fn func() error) {
+func newInternalServer(addr string, h http.Handler, errLog *log.Logger) *http.Server {
+	return &http.Server{
+		Addr:         addr,
+		Handler:      h,
+		ErrorLog:     errLog,
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+}
+
+func (app *application) serveInternal(name string, srv *http.Server) {
+	app.logger.Info("starting "+name+" server", slog.String("addr", srv.Addr))
+
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		app.logger.Error(name+" server stopped", slog.Any("err", err))
+	}
+}
+
+// Run fn after the response has been sent, with ctx detached from the
+// request's cancellation but keeping its values, so mail sends stay in
+// the request's trace and failures are logged with its request ID.
+func (app *application) background(ctx context.Context, fn func(ctx context.Context) error) {
+	ctx = context.WithoutCancel(ctx)
+
 	app.wg.Add(1)
 
 	go func() {
 		defer app.wg.Done()
 
+		app.prom.backgroundStarted()
+
 		defer func() {
 			if err := recover(); err != nil {
-				app.logger.Error("background process recovered from panic", slog.Any("err", err))
+				app.logger.ErrorContext(ctx, "background process recovered from panic", slog.Any("err", err))
+				app.prom.backgroundFinished("panic")
 			}
 		}()
 
-		if err := fn(); err != nil {
-			app.logger.Error("background process returned error", slog.Any("err", err))
+		if err := fn(ctx); err != nil {
+			app.logger.ErrorContext(ctx, "background process returned error", slog.Any("err", err))
+			app.prom.backgroundFinished("error")
+			return
 		}
+
+		app.prom.backgroundFinished("ok")
 	}()
 }
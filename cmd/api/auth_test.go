@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// Follows client/auth-flow.rest from registration through to changing
+// email, checking the failure cases along the way.
+func TestAuthFlow(t *testing.T) {
+	app, mb := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	email := uniqueEmail("auth-flow", "example.com")
+	newEmail := uniqueEmail("auth-flow-new", "example.org")
+	cleanupUsers(t, app, email, newEmail)
+
+	// Request registration token
+	status, env := ts.request(t, http.MethodPost, "/api/v1/tokens/verification/registration", "", map[string]string{
+		"email": email,
+	})
+	if status != http.StatusOK || env["message"] != verificationMsg {
+		t.Fatalf("registration token: got %d %v", status, env)
+	}
+	registrationToken := mb.token(t, email)
+
+	// A second request doesn't mail another token
+	ts.request(t, http.MethodPost, "/api/v1/tokens/verification/registration", "", map[string]string{
+		"email": email,
+	})
+	if n := mb.count(email); n != 1 {
+		t.Errorf("registration mails: got %d; want 1", n)
+	}
+
+	// Use token to create an account
+	status, env = ts.request(t, http.MethodPost, "/api/v1/users", "", map[string]string{
+		"email":    email,
+		"password": "secret-password",
+		"token":    "WRONGTOKENWRONGTOKENWRONGT",
+	})
+	if status != http.StatusUnauthorized {
+		t.Errorf("create user with wrong token: got %d %v", status, env)
+	}
+
+	status, env = ts.request(t, http.MethodPost, "/api/v1/users", "", map[string]string{
+		"email":    email,
+		"password": "short",
+		"token":    registrationToken,
+	})
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("create user with short password: got %d %v", status, env)
+	}
+
+	status, env = ts.request(t, http.MethodPost, "/api/v1/users", "", map[string]string{
+		"email":    email,
+		"password": "secret-password",
+		"token":    registrationToken,
+	})
+	if status != http.StatusCreated {
+		t.Fatalf("create user: got %d %v", status, env)
+	}
+	if got := env["user"].(map[string]any)["email"]; got != email {
+		t.Errorf("create user: got email %q; want %q", got, email)
+	}
+
+	// The registration token is consumed
+	status, _ = ts.request(t, http.MethodPost, "/api/v1/users", "", map[string]string{
+		"email":    email,
+		"password": "secret-password",
+		"token":    registrationToken,
+	})
+	if status != http.StatusUnauthorized {
+		t.Errorf("reuse registration token: got %d; want %d", status, http.StatusUnauthorized)
+	}
+
+	// Request password reset token
+	status, env = ts.request(t, http.MethodPost, "/api/v1/tokens/verification/password-reset", "", map[string]string{
+		"email": email,
+	})
+	if status != http.StatusOK || env["message"] != verificationMsg {
+		t.Fatalf("password reset token: got %d %v", status, env)
+	}
+	resetToken := mb.token(t, email)
+
+	// Unknown emails get the same response and no mail
+	unknown := uniqueEmail("auth-flow-unknown", "example.com")
+	status, env = ts.request(t, http.MethodPost, "/api/v1/tokens/verification/password-reset", "", map[string]string{
+		"email": unknown,
+	})
+	if status != http.StatusOK || env["message"] != verificationMsg || mb.count(unknown) != 0 {
+		t.Errorf("password reset for unknown email: got %d %v", status, env)
+	}
+
+	// Login before the reset, the token is revoked by it
+	status, env = ts.request(t, http.MethodPost, "/api/v1/tokens/authentication", "", map[string]string{
+		"email":    email,
+		"password": "secret-password",
+	})
+	if status != http.StatusCreated {
+		t.Fatalf("login: got %d %v", status, env)
+	}
+	oldAuthToken := env["authentication_token"].(map[string]any)["token"].(string)
+
+	// Use token to change password
+	status, env = ts.request(t, http.MethodPut, "/api/v1/users/password", "", map[string]string{
+		"email":    email,
+		"password": "helloworld",
+		"token":    resetToken,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("change password: got %d %v", status, env)
+	}
+
+	status, _ = ts.request(t, http.MethodGet, "/api/v1/users/me", oldAuthToken, nil)
+	if status != http.StatusUnauthorized {
+		t.Errorf("authentication token after password change: got %d; want %d", status, http.StatusUnauthorized)
+	}
+
+	// Request authentication token in exchange for credentials
+	status, env = ts.request(t, http.MethodPost, "/api/v1/tokens/authentication", "", map[string]string{
+		"email":    email,
+		"password": "secret-password",
+	})
+	if status != http.StatusUnauthorized || env["error"] != InvalidCredentailsMessage {
+		t.Errorf("login with old password: got %d %v", status, env)
+	}
+
+	status, env = ts.request(t, http.MethodPost, "/api/v1/tokens/authentication", "", map[string]string{
+		"email":    email,
+		"password": "helloworld",
+	})
+	if status != http.StatusCreated {
+		t.Fatalf("login: got %d %v", status, env)
+	}
+	authToken := env["authentication_token"].(map[string]any)["token"].(string)
+
+	// Use auth token to get user information
+	status, env = ts.request(t, http.MethodGet, "/api/v1/users/me", "", nil)
+	if status != http.StatusUnauthorized || env["error"] != AuthenticationRequiredMessage {
+		t.Errorf("get user without token: got %d %v", status, env)
+	}
+
+	status, env = ts.request(t, http.MethodGet, "/api/v1/users/me", authToken, nil)
+	if status != http.StatusOK {
+		t.Fatalf("get user: got %d %v", status, env)
+	}
+	if got := env["user"].(map[string]any)["email"]; got != email {
+		t.Errorf("get user: got email %q; want %q", got, email)
+	}
+
+	// Request a verification token to change email
+	status, env = ts.request(t, http.MethodPost, "/api/v1/tokens/verification/email-change", authToken, map[string]string{
+		"email": newEmail,
+	})
+	if status != http.StatusOK || env["message"] != verificationMsg {
+		t.Fatalf("email change token: got %d %v", status, env)
+	}
+	emailChangeToken := mb.token(t, newEmail)
+
+	// Update user with new email address
+	status, env = ts.request(t, http.MethodPut, "/api/v1/users/me", authToken, map[string]string{
+		"email": newEmail,
+		"token": emailChangeToken,
+	})
+	if status != http.StatusCreated {
+		t.Fatalf("change email: got %d %v", status, env)
+	}
+	if got := env["user"].(map[string]any)["email"]; got != newEmail {
+		t.Errorf("change email: got email %q; want %q", got, newEmail)
+	}
+}
+
+func TestInvalidAuthenticationToken(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	status, env := ts.request(t, http.MethodGet, "/api/v1/users/me", "NOTAREALTOKENNOTAREALTOKEN", nil)
+	if status != http.StatusUnauthorized || env["error"] != InvalidAuthenticationTokenMessage {
+		t.Errorf("got %d %v", status, env)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	app, _ := newTestApplication(t)
+
+	cfg := app.config()
+	cfg.limiter.enabled = true
+	cfg.limiter.rps = 0.1
+	cfg.limiter.burst = 2
+	app.setConfig(cfg)
+
+	ts := newTestServer(t, app)
+
+	get := func(path, token string) *http.Response {
+		t.Helper()
+
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		return res
+	}
+
+	for i := range 2 {
+		res := get("/api/v1/healthcheck", "")
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: got %d", i+1, res.StatusCode)
+		}
+		if got, want := res.Header.Get("RateLimit-Remaining"), strconv.Itoa(1-i); got != want {
+			t.Errorf("request %d: RateLimit-Remaining %q; want %q", i+1, got, want)
+		}
+	}
+
+	status, env := ts.request(t, http.MethodGet, "/api/v1/healthcheck", "", nil)
+	if status != http.StatusTooManyRequests || env["error"] != RateLimitExceededMessage {
+		t.Errorf("over limit: got %d %v", status, env)
+	}
+
+	res := get("/api/v1/healthcheck", "")
+	if res.Header.Get("RateLimit-Limit") != "2" || res.Header.Get("RateLimit-Remaining") != "0" || res.Header.Get("Retry-After") != "10" {
+		t.Errorf("over limit headers: %v", res.Header)
+	}
+
+	// Policies have separate budgets
+	status, env = ts.request(t, http.MethodPost, "/api/v1/tokens/verification/registration", "", map[string]string{
+		"email": uniqueEmail("rate-limit", "example.com"),
+	})
+	if status != http.StatusOK {
+		t.Errorf("other policy: got %d %v", status, env)
+	}
+
+	// Authenticated clients are limited by user rather than address
+	var tokens []string
+	for _, name := range []string{"jane", "john"} {
+		email := uniqueEmail(name, "example.com")
+		cleanupUsers(t, app, email)
+
+		user, err := app.models.User.New(context.Background(), email, "secret-password")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := app.models.AuthenticationToken.New(context.Background(), user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tokens = append(tokens, token.Plaintext)
+	}
+
+	limited := false
+	for range 5 * userRateLimit.limit.Burst {
+		if res := get("/api/v1/users/me", tokens[0]); res.StatusCode == http.StatusTooManyRequests {
+			limited = true
+			break
+		}
+	}
+	if !limited {
+		t.Fatal("user was never limited")
+	}
+	if res := get("/api/v1/users/me", tokens[1]); res.StatusCode != http.StatusOK {
+		t.Errorf("other user: got %d", res.StatusCode)
+	}
+}
+
+func TestInvalidAuthenticationTokenRateLimit(t *testing.T) {
+	app, _ := newTestApplication(t)
+
+	cfg := app.config()
+	cfg.limiter.enabled = true
+	cfg.limiter.rps = 100
+	cfg.limiter.burst = 100
+	app.setConfig(cfg)
+
+	ts := newTestServer(t, app)
+
+	// Guesses are charged to the auth policy before any route policy
+	for i := range authRateLimit.limit.Burst {
+		status, env := ts.request(t, http.MethodGet, "/api/v1/healthcheck", fmt.Sprintf("GUESS%021d", i), nil)
+		if status != http.StatusUnauthorized {
+			t.Fatalf("guess %d: got %d %v", i+1, status, env)
+		}
+	}
+
+	status, env := ts.request(t, http.MethodGet, "/api/v1/healthcheck", "GUESSAGAINGUESSAGAINGUESSA", nil)
+	if status != http.StatusTooManyRequests || env["error"] != RateLimitExceededMessage {
+		t.Errorf("over limit: got %d %v", status, env)
+	}
+
+	// Anonymous requests from the address are still served
+	status, _ = ts.request(t, http.MethodGet, "/api/v1/healthcheck", "", nil)
+	if status != http.StatusOK {
+		t.Errorf("anonymous: got %d", status)
+	}
+}
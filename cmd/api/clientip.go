@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Parse a comma separated list of CIDR prefixes or single addresses.
+func parseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// Forwarding headers a trusted proxy may be configured to write
+var forwardingHeaders = []string{"Forwarded", "X-Forwarded-For", "X-Real-IP"}
+
+// Canonical name of a supported forwarding header, or "" if s isn't one.
+func forwardingHeader(s string) string {
+	for _, name := range forwardingHeaders {
+		if strings.EqualFold(s, name) {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// Resolves the client address of requests which may have passed
+// through reverse proxies. Only the forwarding header the proxies
+// write is believed, and only when added by a trusted proxy. Proxies
+// pass other headers through untouched, so clients could forge them.
+type clientIPResolver struct {
+	trusted []netip.Prefix
+	header  string
+}
+
+func (c clientIPResolver) isTrusted(addr netip.Addr) bool {
+	for _, p := range c.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Walk the forwarding chain from the nearest hop back towards the
+// client, stopping at the first address not belonging to a trusted
+// proxy, since anything before it may have been forged.
+func (c clientIPResolver) resolve(r *http.Request) netip.Addr {
+	peer := parseHost(r.RemoteAddr)
+	if !peer.IsValid() || !c.isTrusted(peer) {
+		return peer
+	}
+
+	client := peer
+	chain := forwardedFor(r.Header, c.header)
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr := parseHost(chain[i])
+		if !addr.IsValid() {
+			// Obfuscated or malformed, the last trusted hop is all we know
+			break
+		}
+
+		client = addr
+		if !c.isTrusted(addr) {
+			break
+		}
+	}
+
+	return client
+}
+
+// Addresses from the forwarding header, ordered from client to nearest
+// proxy.
+func forwardedFor(h http.Header, header string) []string {
+	var chain []string
+
+	switch header {
+	case "Forwarded":
+		for _, line := range h.Values("Forwarded") {
+			for _, element := range strings.Split(line, ",") {
+				node := "unknown"
+				for _, pair := range strings.Split(element, ";") {
+					key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+					if ok && strings.EqualFold(key, "for") {
+						node = strings.Trim(value, `"`)
+					}
+				}
+				chain = append(chain, node)
+			}
+		}
+	case "X-Forwarded-For":
+		for _, line := range h.Values("X-Forwarded-For") {
+			for _, addr := range strings.Split(line, ",") {
+				chain = append(chain, strings.TrimSpace(addr))
+			}
+		}
+	case "X-Real-IP":
+		if ip := h.Get("X-Real-IP"); ip != "" {
+			chain = append(chain, strings.TrimSpace(ip))
+		}
+	}
+
+	return chain
+}
+
+// Parse an address with optional port, e.g. 192.0.2.1, [2001:db8::1]
+// or 192.0.2.1:4711. Returns the zero Addr if invalid.
+func parseHost(s string) netip.Addr {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	return addr.Unmap()
+}
+
+// Store the resolved client address in the request context.
+func (app *application) clientIP(next http.Handler) http.Handler {
+	cfg := app.config()
+	resolver := clientIPResolver{cfg.trustedProxies(), forwardingHeader(cfg.trustedProxyHeader)}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, contextSetClientIP(r, resolver.resolve(r)))
+	})
+}
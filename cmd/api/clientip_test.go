@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.1, 2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const (
+		fwd = "Forwarded"
+		xff = "X-Forwarded-For"
+		xri = "X-Real-IP"
+	)
+
+	tests := []struct {
+		name    string
+		header  string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"direct", xff, "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"untrusted peer ignores headers", xff, "203.0.113.7:5000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "203.0.113.7"},
+		{"trusted peer without headers", xff, "10.1.2.3:5000", nil, "10.1.2.3"},
+		{"x-forwarded-for", xff, "10.1.2.3:5000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"spoofed entry before client", xff, "10.1.2.3:5000", map[string]string{"X-Forwarded-For": "1.1.1.1, 198.51.100.1, 10.9.9.9"}, "198.51.100.1"},
+		{"x-real-ip", xri, "192.0.2.1:5000", map[string]string{"X-Real-IP": "198.51.100.2"}, "198.51.100.2"},
+		{"forwarded", fwd, "10.1.2.3:5000", map[string]string{"Forwarded": `for=198.51.100.3;proto=https, for="[2001:db8:cafe::17]:4711"`}, "198.51.100.3"},
+		{"forwarded ipv6 client", fwd, "10.1.2.3:5000", map[string]string{"Forwarded": `for="[2001:db9::1]:4711"`}, "2001:db9::1"},
+		{"obfuscated", fwd, "10.1.2.3:5000", map[string]string{"Forwarded": "for=_hidden, for=10.2.2.2"}, "10.2.2.2"},
+		{"all trusted", xff, "10.1.2.3:5000", map[string]string{"X-Forwarded-For": "10.4.4.4, 10.5.5.5"}, "10.4.4.4"},
+		{"ipv4 mapped peer", xff, "[::ffff:10.1.2.3]:5000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		// The proxy appends X-Forwarded-For and passes the others through
+		{"forged forwarded", xff, "10.1.2.3:5000", map[string]string{"Forwarded": "for=1.2.3.4", "X-Forwarded-For": "198.51.100.4"}, "198.51.100.4"},
+		{"forged x-real-ip", xff, "10.1.2.3:5000", map[string]string{"X-Real-IP": "1.2.3.4"}, "10.1.2.3"},
+		{"forged x-forwarded-for", fwd, "10.1.2.3:5000", map[string]string{"X-Forwarded-For": "1.2.3.4", "Forwarded": "for=198.51.100.5"}, "198.51.100.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+
+			resolver := clientIPResolver{trusted, tt.header}
+			if got := resolver.resolve(r).String(); got != tt.want {
+				t.Errorf("got %s; want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	for _, s := range []string{"10.0.0.0/33", "not-an-ip", "10.0.0.0/8,,bad"} {
+		if _, err := parseTrustedProxies(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+
+	prefixes, err := parseTrustedProxies("")
+	if err != nil || len(prefixes) != 0 {
+		t.Errorf("empty: got %v, %v", prefixes, err)
+	}
+}
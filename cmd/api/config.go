@@ -0,0 +1,622 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+type config struct {
+	baseURL            string
+	port               int
+	dev                bool
+	logLevel           string
+	migrateOnStart     bool
+	shutdownDelay      time.Duration
+	metricsAddr        string
+	debugAddr          string
+	maxBodySize        int64
+	trustedProxyCIDRs  string
+	trustedProxyHeader string
+	db                 struct {
+		dsn string
+	}
+	limiter struct {
+		rps     float64
+		burst   int
+		enabled bool
+		store   string
+	}
+	session struct {
+		cookie   bool
+		sameSite string
+	}
+	cors struct {
+		origins     string
+		methods     string
+		headers     string
+		credentials bool
+	}
+	ban struct {
+		threshold int
+		window    time.Duration
+		duration  time.Duration
+	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	mail struct {
+		webhookSecret    string
+		recipientPerHour int
+		recipientPerDay  int
+		domainPerHour    int
+		domainPerDay     int
+	}
+	dkim struct {
+		domain   string
+		selector string
+		keyFile  string
+	}
+	trace struct {
+		endpoint    string
+		sampleRatio float64
+	}
+}
+
+// Command line only options
+type options struct {
+	configFile  string
+	printConfig bool
+	version     bool
+}
+
+// Flags which may only be set on the command line
+var cliOnlyFlags = map[string]bool{
+	"config":       true,
+	"print-config": true,
+	"version":      true,
+}
+
+// Flags redacted by -print-config
+var secretFlags = map[string]bool{
+	"db-dsn":              true,
+	"smtp-password":       true,
+	"mail-webhook-secret": true,
+}
+
+// Flag prefixes which become a section in the config file
+var configSections = []string{"db", "smtp", "limiter", "ban", "session", "cors", "mail", "dkim", "trace"}
+
+// Create flag set with every configuration flag and the command line
+// only options.
+func newFlagSet(name string, errorHandling flag.ErrorHandling, cfg *config, opts *options) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, errorHandling)
+
+	cfg.registerFlags(fs)
+
+	fs.StringVar(&opts.configFile, "config", "", "Configuration file (TOML, YAML or JSON)")
+	fs.BoolVar(&opts.printConfig, "print-config", false, "Display configuration with secrets redacted and exit")
+	fs.BoolVar(&opts.version, "version", false, "Display version and exit")
+
+	return fs
+}
+
+// Load and validate configuration from args and the environment,
+// discarding the command line only options.
+func parseConfig(args []string, lookupEnv func(string) (string, bool)) (config, error) {
+	var cfg config
+	var opts options
+
+	fs := newFlagSet("api", flag.ContinueOnError, &cfg, &opts)
+	fs.SetOutput(io.Discard)
+
+	err := loadConfig(fs, args, lookupEnv)
+	if err != nil {
+		return cfg, err
+	}
+
+	return cfg, cfg.validate()
+}
+
+// Register configuration flags with default values for production.
+func (cfg *config) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.baseURL, "base-url", "http://127.0.0.1:5173", "Base URL")
+	fs.IntVar(&cfg.port, "port", 8080, "API server port")
+	fs.BoolVar(&cfg.dev, "dev", false, "Development mode")
+	fs.DurationVar(&cfg.shutdownDelay, "shutdown-delay", 0, "Time to report not ready before draining connections on shutdown")
+	fs.StringVar(&cfg.metricsAddr, "metrics-addr", "127.0.0.1:9091", "Listen address for the Prometheus metrics endpoint, empty to disable")
+	fs.Int64Var(&cfg.maxBodySize, "max-body-size", 1<<20, "Maximum request body size in bytes")
+	fs.StringVar(&cfg.debugAddr, "debug-addr", "", "Listen address for the pprof and expvar debug endpoints, localhost if only a port is given (disabled if empty)")
+	fs.StringVar(&cfg.trustedProxyCIDRs, "trusted-proxies", "", "Comma separated CIDRs of reverse proxies whose forwarding header is trusted")
+	fs.StringVar(&cfg.trustedProxyHeader, "trusted-proxy-header", "X-Forwarded-For", "Forwarding header written by the trusted proxies: Forwarded, X-Forwarded-For or X-Real-IP (others are ignored)")
+	fs.BoolVar(&cfg.session.cookie, "session-cookie", false, "Allow signing in with an HttpOnly session cookie as an alternative to bearer tokens")
+	fs.StringVar(&cfg.session.sameSite, "session-same-site", "strict", "SameSite attribute of session cookies: strict, lax or none")
+	fs.StringVar(&cfg.cors.origins, "cors-origins", "", "Comma separated origins allowed to make cross-origin requests, or * for any (default the origin of -base-url)")
+	fs.StringVar(&cfg.cors.methods, "cors-methods", "GET,POST,PUT,PATCH,DELETE", "Comma separated methods allowed in cross-origin requests")
+	fs.StringVar(&cfg.cors.headers, "cors-headers", "Authorization,Content-Type,X-CSRF-Token,X-Request-ID", "Comma separated request headers allowed in cross-origin requests")
+	fs.BoolVar(&cfg.cors.credentials, "cors-credentials", false, "Allow cross-origin requests with cookies")
+	fs.StringVar(&cfg.logLevel, "log-level", "", "Log level: debug, info, warn or error (default debug in development mode, otherwise info)")
+
+	fs.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
+	fs.BoolVar(&cfg.migrateOnStart, "migrate-on-start", false, "Apply pending database migrations on startup")
+
+	fs.StringVar(&cfg.smtp.host, "smtp-host", "", "SMTP host")
+	fs.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
+	fs.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	fs.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
+	fs.StringVar(&cfg.smtp.sender, "smtp-sender", "", "SMTP sender")
+
+	fs.StringVar(&cfg.mail.webhookSecret, "mail-webhook-secret", "", "Shared secret for bounce and complaint webhook (disabled if empty)")
+	fs.IntVar(&cfg.mail.recipientPerHour, "mail-limit-recipient-hour", 5, "Maximum emails sent to a single recipient per hour (0 to disable)")
+	fs.IntVar(&cfg.mail.recipientPerDay, "mail-limit-recipient-day", 20, "Maximum emails sent to a single recipient per day (0 to disable)")
+	fs.IntVar(&cfg.mail.domainPerHour, "mail-limit-domain-hour", 100, "Maximum emails sent to a single domain per hour (0 to disable)")
+	fs.IntVar(&cfg.mail.domainPerDay, "mail-limit-domain-day", 1000, "Maximum emails sent to a single domain per day (0 to disable)")
+
+	fs.StringVar(&cfg.dkim.domain, "dkim-domain", "", "DKIM signing domain")
+	fs.StringVar(&cfg.dkim.selector, "dkim-selector", "", "DKIM selector")
+	fs.StringVar(&cfg.dkim.keyFile, "dkim-key", "", "DKIM private key file (PEM encoded RSA or Ed25519)")
+
+	fs.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	fs.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	fs.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	fs.StringVar(&cfg.limiter.store, "limiter-store", "memory", "Rate limiter store: memory (per server) or postgres (shared by all servers). Requests are allowed if the store fails")
+
+	fs.IntVar(&cfg.ban.threshold, "ban-threshold", 20, "Rate limit rejections within -ban-window before an IP address is temporarily banned (0 to disable)")
+	fs.DurationVar(&cfg.ban.window, "ban-window", time.Minute, "Window for counting rate limit rejections towards a ban")
+	fs.DurationVar(&cfg.ban.duration, "ban-duration", 15*time.Minute, "Duration of automatic IP address bans")
+
+	fs.StringVar(&cfg.trace.endpoint, "trace-endpoint", "", "OTLP/HTTP collector URL for traces, e.g. http://localhost:4318 (disabled if empty)")
+	fs.Float64Var(&cfg.trace.sampleRatio, "trace-sample-ratio", 1, "Fraction of new traces to sample")
+}
+
+// Config file key for flag, e.g. smtp-host is smtp.host and
+// base-url is base_url.
+func configKey(flagName string) string {
+	for _, section := range configSections {
+		if name, ok := strings.CutPrefix(flagName, section+"-"); ok {
+			return section + "." + strings.ReplaceAll(name, "-", "_")
+		}
+	}
+
+	return strings.ReplaceAll(flagName, "-", "_")
+}
+
+// Environment variable for config key, e.g. smtp.host is API_SMTP_HOST.
+func envKey(key string) string {
+	return "API_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// Parse args and populate the flags in fs from, in increasing order
+// of precedence: flag defaults, the file given by -config, API_*
+// environment variables and flags set in args.
+func loadConfig(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) error {
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	// Remember explicitly set flags so they can be applied last
+	explicit := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	settings := map[string]*flag.Flag{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if !cliOnlyFlags[f.Name] {
+			settings[configKey(f.Name)] = f
+		}
+	})
+
+	if f := fs.Lookup("config"); f != nil && f.Value.String() != "" {
+		path := f.Value.String()
+
+		values, err := readConfigFile(path)
+		if err != nil {
+			return fmt.Errorf("config: %s: %w", path, err)
+		}
+
+		for key, value := range values {
+			s, ok := settings[key]
+			if !ok {
+				return fmt.Errorf("config: %s: unknown key %q", path, key)
+			}
+
+			err := s.Value.Set(value)
+			if err != nil {
+				return fmt.Errorf("config: %s: invalid value %q for key %q: %w", path, value, key, err)
+			}
+		}
+	}
+
+	for key, s := range settings {
+		name := envKey(key)
+
+		value, ok := lookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		err := s.Value.Set(value)
+		if err != nil {
+			return fmt.Errorf("config: invalid value %q for %s: %w", value, name, err)
+		}
+	}
+
+	for name, value := range explicit {
+		err := fs.Set(name, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Read a TOML, YAML or JSON file, chosen by extension, into a map of
+// flattened keys to string values.
+func readConfigFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree map[string]any
+
+	switch ext := filepath.Ext(path); ext {
+	case ".toml":
+		err = toml.Unmarshal(b, &tree)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &tree)
+	case ".json":
+		err = json.Unmarshal(b, &tree)
+	default:
+		return nil, fmt.Errorf("unsupported file extension %q", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+
+	err = flattenConfig(values, "", tree)
+	if err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+func flattenConfig(dst map[string]string, prefix string, tree map[string]any) error {
+	for k, v := range tree {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+
+		switch v := v.(type) {
+		case map[string]any:
+			err := flattenConfig(dst, key, v)
+			if err != nil {
+				return err
+			}
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = configString(item)
+			}
+			dst[key] = strings.Join(items, ",")
+		case nil:
+			return fmt.Errorf("missing value for key %q", key)
+		default:
+			dst[key] = configString(v)
+		}
+	}
+
+	return nil
+}
+
+func configString(v any) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Check the configuration is usable before starting the server.
+func (cfg config) validate() error {
+	var errs []error
+
+	u, err := url.Parse(cfg.baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("base_url: must be an absolute http(s) URL"))
+	}
+
+	if cfg.logLevel != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(cfg.logLevel)); err != nil {
+			errs = append(errs, errors.New("log_level: must be debug, info, warn or error"))
+		}
+	}
+
+	if cfg.shutdownDelay < 0 {
+		errs = append(errs, errors.New("shutdown_delay: must not be negative"))
+	}
+
+	if cfg.port < 1 || cfg.port > 65535 {
+		errs = append(errs, errors.New("port: must be between 1 and 65535"))
+	}
+
+	if cfg.maxBodySize <= 0 {
+		errs = append(errs, errors.New("max_body_size: must be greater than 0"))
+	}
+
+	if _, err := parseTrustedProxies(cfg.trustedProxyCIDRs); err != nil {
+		errs = append(errs, fmt.Errorf("trusted_proxies: %w", err))
+	}
+
+	if forwardingHeader(cfg.trustedProxyHeader) == "" {
+		errs = append(errs, errors.New("trusted_proxy_header: must be Forwarded, X-Forwarded-For or X-Real-IP"))
+	}
+
+	if cfg.metricsAddr != "" {
+		if _, _, err := net.SplitHostPort(cfg.metricsAddr); err != nil {
+			errs = append(errs, errors.New("metrics_addr: must be host:port"))
+		}
+	}
+
+	switch cfg.session.sameSite {
+	case "strict", "lax", "none":
+	default:
+		errs = append(errs, errors.New("session.same_site: must be strict, lax or none"))
+	}
+
+	for _, origin := range splitList(cfg.cors.origins) {
+		if origin == "*" {
+			if cfg.cors.credentials {
+				errs = append(errs, errors.New("cors.origins: * can't be used with cors.credentials"))
+			}
+			continue
+		}
+
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			(u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			errs = append(errs, fmt.Errorf("cors.origins: %q must be an http(s) origin", origin))
+		}
+	}
+
+	if cfg.debugAddr != "" {
+		if _, _, err := net.SplitHostPort(cfg.debugAddr); err != nil {
+			errs = append(errs, errors.New("debug_addr: must be host:port or :port"))
+		}
+	}
+
+	if cfg.db.dsn == "" {
+		errs = append(errs, errors.New("db.dsn: must be provided"))
+	}
+
+	if cfg.smtp.port < 1 || cfg.smtp.port > 65535 {
+		errs = append(errs, errors.New("smtp.port: must be between 1 and 65535"))
+	}
+
+	if cfg.limiter.enabled {
+		if cfg.limiter.rps <= 0 {
+			errs = append(errs, errors.New("limiter.rps: must be greater than 0"))
+		}
+		if cfg.limiter.burst <= 0 {
+			errs = append(errs, errors.New("limiter.burst: must be greater than 0"))
+		}
+		if cfg.limiter.store != "memory" && cfg.limiter.store != "postgres" {
+			errs = append(errs, errors.New("limiter.store: must be memory or postgres"))
+		}
+	}
+
+	if cfg.ban.threshold < 0 {
+		errs = append(errs, errors.New("ban.threshold: must not be negative"))
+	}
+	if cfg.ban.threshold > 0 {
+		if cfg.ban.window <= 0 {
+			errs = append(errs, errors.New("ban.window: must be greater than 0"))
+		}
+		if cfg.ban.duration <= 0 {
+			errs = append(errs, errors.New("ban.duration: must be greater than 0"))
+		}
+	}
+
+	if cfg.mail.recipientPerHour < 0 || cfg.mail.recipientPerDay < 0 ||
+		cfg.mail.domainPerHour < 0 || cfg.mail.domainPerDay < 0 {
+		errs = append(errs, errors.New("mail.limit_*: must not be negative"))
+	}
+
+	if cfg.trace.endpoint != "" {
+		u, err := url.Parse(cfg.trace.endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, errors.New("trace.endpoint: must be an absolute http(s) URL"))
+		}
+	}
+
+	if cfg.trace.sampleRatio < 0 || cfg.trace.sampleRatio > 1 {
+		errs = append(errs, errors.New("trace.sample_ratio: must be between 0 and 1"))
+	}
+
+	if cfg.dkim.keyFile != "" && (cfg.dkim.domain == "" || cfg.dkim.selector == "") {
+		errs = append(errs, errors.New("dkim.key: dkim.domain and dkim.selector are required"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Networks of trusted reverse proxies.
+func (cfg config) trustedProxies() []netip.Prefix {
+	// Already checked by validate
+	prefixes, _ := parseTrustedProxies(cfg.trustedProxyCIDRs)
+
+	return prefixes
+}
+
+// SameSite attribute for session cookies.
+func (cfg config) sessionSameSite() http.SameSite {
+	switch cfg.session.sameSite {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteStrictMode
+	}
+}
+
+// Origins allowed to make cross-origin requests, normalized to match
+// the Origin header.
+func (cfg config) corsOrigins() []string {
+	if cfg.cors.origins == "" {
+		// Already checked by validate
+		u, _ := url.Parse(cfg.baseURL)
+		return []string{strings.ToLower(u.Scheme + "://" + u.Host)}
+	}
+
+	var origins []string
+	for _, origin := range splitList(cfg.cors.origins) {
+		origins = append(origins, strings.ToLower(strings.TrimSuffix(origin, "/")))
+	}
+
+	return origins
+}
+
+// Split a comma separated list, ignoring empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// Listen address for debug endpoints, bound to localhost unless a
+// host is given.
+func (cfg config) debugListenAddr() string {
+	// Already checked by validate
+	host, port, _ := net.SplitHostPort(cfg.debugAddr)
+	if host == "" {
+		host = "127.0.0.1"
+	}
+
+	return net.JoinHostPort(host, port)
+}
+
+// Level for the application logger.
+func (cfg config) slogLevel() slog.Level {
+	if cfg.logLevel == "" {
+		if cfg.dev {
+			return slog.LevelDebug
+		}
+		return slog.LevelInfo
+	}
+
+	var level slog.Level
+	// Already checked by validate
+	_ = level.UnmarshalText([]byte(cfg.logLevel))
+
+	return level
+}
+
+// Flattened config keys and their values.
+func (cfg config) values() map[string]string {
+	var c config
+
+	// Flag values point into c, so assign after registering defaults
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	c.registerFlags(fs)
+	c = cfg
+
+	values := map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) {
+		values[configKey(f.Name)] = f.Value.String()
+	})
+
+	return values
+}
+
+// Report whether the config key holds a secret.
+func isSecretKey(key string) bool {
+	for name := range secretFlags {
+		if configKey(name) == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Write the effective configuration of fs as TOML with secret values
+// redacted.
+func printConfig(w io.Writer, fs *flag.FlagSet) {
+	sections := map[string][]*flag.Flag{}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if cliOnlyFlags[f.Name] {
+			return
+		}
+
+		section, _, ok := strings.Cut(configKey(f.Name), ".")
+		if !ok {
+			section = ""
+		}
+
+		sections[section] = append(sections[section], f)
+	})
+
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, section := range names {
+		if section != "" {
+			fmt.Fprintf(w, "\n[%s]\n", section)
+		}
+
+		for _, f := range sections[section] {
+			key := strings.TrimPrefix(configKey(f.Name), section+".")
+
+			switch v := f.Value.(flag.Getter).Get().(type) {
+			case time.Duration:
+				fmt.Fprintf(w, "%s = %q\n", key, v)
+			case string:
+				if secretFlags[f.Name] && v != "" {
+					v = "[REDACTED]"
+				}
+				fmt.Fprintf(w, "%s = %q\n", key, v)
+			default:
+				fmt.Fprintf(w, "%s = %v\n", key, v)
+			}
+		}
+	}
+}
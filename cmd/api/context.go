@@ -3,15 +3,26 @@ package main
 import (
 	"context"
 	"net/http"
+	"net/netip"
 
 	"github.com/micahco/api/internal/data"
 )
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey      = contextKey("user")
+	requestIDContextKey = contextKey("request_id")
+	accessLogContextKey = contextKey("access_log")
+	clientIPContextKey  = contextKey("client_ip")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	// Let the access log, which wraps authentication, see the user
+	if entry, ok := r.Context().Value(accessLogContextKey).(*accessLogEntry); ok && !user.IsAnonymous() {
+		entry.userID = user.ID.String()
+	}
+
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
@@ -24,3 +35,35 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+func contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// Request ID from ctx, empty outside of a request.
+func contextGetRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+func contextSetClientIP(r *http.Request, addr netip.Addr) *http.Request {
+	ctx := context.WithValue(r.Context(), clientIPContextKey, addr)
+	return r.WithContext(ctx)
+}
+
+// Client address resolved by the clientIP middleware, falling back to
+// the connection's peer address.
+func contextGetClientIP(r *http.Request) string {
+	if addr := contextClientIP(r.Context()); addr.IsValid() {
+		return addr.String()
+	}
+
+	return r.RemoteAddr
+}
+
+// Client address from ctx, invalid outside of a request.
+func contextClientIP(ctx context.Context) netip.Addr {
+	addr, _ := ctx.Value(clientIPContextKey).(netip.Addr)
+	return addr
+}
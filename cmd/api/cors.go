@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Response headers cross-origin clients may read, besides the
+// CORS-safelisted ones
+var corsExposedHeaders = []string{
+	requestIDHeader,
+	"RateLimit-Limit",
+	"RateLimit-Remaining",
+	"Retry-After",
+}
+
+// Allow frontends hosted on other origins to call the API. Preflight
+// requests are answered here, before routing, since the routes don't
+// handle OPTIONS. A preflight from another origin, or asking for a
+// method or header that isn't allowed, is rejected.
+func (app *application) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Origins may change when configuration is reloaded
+		cfg := app.config()
+
+		w.Header().Add("Vary", "Origin")
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		origin := r.Header.Get("Origin")
+		origins := cfg.corsOrigins()
+
+		allowed := origin != "" && (slices.Contains(origins, "*") || slices.Contains(origins, strings.ToLower(origin)))
+		if allowed {
+			if slices.Contains(origins, "*") {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+
+			if cfg.cors.credentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
+		if preflight {
+			if !allowed || !corsPreflightAllowed(cfg, r) {
+				app.errorResponse(w, r, http.StatusForbidden, CORSNotAllowedMessage)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(splitList(cfg.cors.methods), ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(splitList(cfg.cors.headers), ", "))
+			w.Header().Set("Access-Control-Max-Age", "600")
+
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if allowed {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Report whether the method and headers requested by a preflight are
+// all allowed. GET, HEAD and POST are always allowed by browsers, so
+// needn't be listed.
+func corsPreflightAllowed(cfg config, r *http.Request) bool {
+	method := r.Header.Get("Access-Control-Request-Method")
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost:
+	default:
+		if !slices.Contains(splitList(cfg.cors.methods), method) {
+			return false
+		}
+	}
+
+	headers := splitList(cfg.cors.headers)
+	for _, requested := range splitList(r.Header.Get("Access-Control-Request-Headers")) {
+		ok := slices.ContainsFunc(headers, func(h string) bool {
+			return strings.EqualFold(h, requested)
+		})
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
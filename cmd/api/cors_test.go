@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	app, _ := newTestApplication(t)
+	cfg := app.config()
+	cfg.cors.methods = "GET,POST"
+	cfg.cors.headers = "Authorization,Content-Type"
+	app.setConfig(cfg)
+
+	h := app.routes()
+
+	serve := func(method, origin string, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+
+		r := httptest.NewRequest(method, "/api/v1/healthcheck", nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+
+		return rr
+	}
+
+	preflight := http.Header{
+		"Access-Control-Request-Method":  {"POST"},
+		"Access-Control-Request-Headers": {"authorization"},
+	}
+
+	t.Run("preflight", func(t *testing.T) {
+		rr := serve(http.MethodOptions, "http://spa.example.com", preflight)
+
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("got %d; want 204", rr.Code)
+		}
+
+		for k, want := range map[string]string{
+			"Access-Control-Allow-Origin":  "http://spa.example.com",
+			"Access-Control-Allow-Methods": "GET, POST",
+			"Access-Control-Allow-Headers": "Authorization, Content-Type",
+		} {
+			if got := rr.Header().Get(k); got != want {
+				t.Errorf("%s: got %q; want %q", k, got, want)
+			}
+		}
+		if !slices.Contains(rr.Header().Values("Vary"), "Origin") {
+			t.Errorf("Vary: got %q", rr.Header().Values("Vary"))
+		}
+		if rr.Header().Get("Access-Control-Allow-Credentials") != "" {
+			t.Error("credentials allowed by default")
+		}
+	})
+
+	t.Run("preflight from other origin", func(t *testing.T) {
+		rr := serve(http.MethodOptions, "http://evil.example.com", preflight)
+
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("got %d; want 403", rr.Code)
+		}
+		if rr.Header().Get("Access-Control-Allow-Origin") != "" || rr.Header().Get("Access-Control-Allow-Methods") != "" {
+			t.Errorf("other origin allowed: %v", rr.Header())
+		}
+	})
+
+	t.Run("preflight not allowed", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			method  string
+			headers string
+			want    int
+		}{
+			{"safelisted method", "HEAD", "", http.StatusNoContent},
+			{"method", "DELETE", "", http.StatusForbidden},
+			{"header", "POST", "authorization, x-forwarded-for", http.StatusForbidden},
+			{"header case", "GET", "Content-Type,AUTHORIZATION", http.StatusNoContent},
+		}
+
+		for _, tt := range tests {
+			header := http.Header{"Access-Control-Request-Method": {tt.method}}
+			if tt.headers != "" {
+				header.Set("Access-Control-Request-Headers", tt.headers)
+			}
+
+			rr := serve(http.MethodOptions, "http://spa.example.com", header)
+			if rr.Code != tt.want {
+				t.Errorf("%s: got %d; want %d", tt.name, rr.Code, tt.want)
+			}
+			if tt.want == http.StatusForbidden && rr.Header().Get("Access-Control-Allow-Methods") != "" {
+				t.Errorf("%s: allow headers set: %v", tt.name, rr.Header())
+			}
+		}
+	})
+
+	t.Run("request", func(t *testing.T) {
+		rr := serve(http.MethodGet, "http://spa.example.com", nil)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got %d; want 200", rr.Code)
+		}
+		if rr.Header().Get("Access-Control-Allow-Origin") != "http://spa.example.com" {
+			t.Errorf("Access-Control-Allow-Origin: got %q", rr.Header().Get("Access-Control-Allow-Origin"))
+		}
+		if rr.Header().Get("Access-Control-Expose-Headers") == "" {
+			t.Error("no exposed headers")
+		}
+	})
+
+	t.Run("same origin", func(t *testing.T) {
+		rr := serve(http.MethodGet, "", nil)
+
+		if rr.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("Access-Control-Allow-Origin: got %q", rr.Header().Get("Access-Control-Allow-Origin"))
+		}
+		if !slices.Contains(rr.Header().Values("Vary"), "Origin") {
+			t.Errorf("Vary: got %q", rr.Header().Values("Vary"))
+		}
+	})
+
+	t.Run("configured origins", func(t *testing.T) {
+		cfg := app.config()
+		cfg.cors.origins = "https://app.example.com/, http://127.0.0.1:5173"
+		cfg.cors.credentials = true
+		app.setConfig(cfg)
+
+		rr := serve(http.MethodGet, "https://app.example.com", nil)
+		if rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+			rr.Header().Get("Access-Control-Allow-Credentials") != "true" {
+			t.Errorf("configured origin: %v", rr.Header())
+		}
+
+		rr = serve(http.MethodGet, "http://spa.example.com", nil)
+		if rr.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("base URL origin allowed: %v", rr.Header())
+		}
+	})
+
+	t.Run("any origin", func(t *testing.T) {
+		cfg := app.config()
+		cfg.cors.origins = "*"
+		cfg.cors.credentials = false
+		app.setConfig(cfg)
+
+		rr := serve(http.MethodGet, "http://anywhere.example.com", nil)
+		if rr.Header().Get("Access-Control-Allow-Origin") != "*" {
+			t.Errorf("Access-Control-Allow-Origin: got %q", rr.Header().Get("Access-Control-Allow-Origin"))
+		}
+	})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDebugRoutes(t *testing.T) {
+	app, _ := newTestApplication(t)
+
+	// Not served by the public router
+	ts := newTestServer(t, app)
+	for _, path := range []string{"/api/debug/vars", "/api/debug/pprof/"} {
+		res, err := ts.Client().Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusNotFound {
+			t.Errorf("public %s: got %d; want 404", path, res.StatusCode)
+		}
+	}
+
+	debug := app.debugRoutes()
+	for _, path := range []string{"/debug/vars", "/debug/pprof/"} {
+		rr := httptest.NewRecorder()
+		debug.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("debug %s: got %d; want 200", path, rr.Code)
+		}
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"mime"
 	"net/http"
+	"strings"
 
 	validation "github.com/go-ozzo/ozzo-validation"
 )
@@ -15,7 +17,11 @@ const (
 	InvalidCredentailsMessage         = "invalid credentials"
 	InvalidAuthenticationTokenMessage = "invalid or expired authentication token"
 	AuthenticationRequiredMessage     = "you must be authenticated to access this resource"
+	AdminRequiredMessage              = "you must be an administrator to access this resource"
 	RateLimitExceededMessage          = "rate limit exceeded"
+	IPBlockedMessage                  = "requests from your network are blocked"
+	CORSNotAllowedMessage             = "cross-origin request not allowed"
+	InvalidCSRFTokenMessage           = "invalid or missing CSRF token"
 )
 
 type envelope map[string]any
@@ -27,36 +33,96 @@ func (app *application) handle(h withError) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := h(w, r); err != nil {
 			var validationError validation.Errors
+			var reqErr *requestError
 			switch {
 			case errors.As(err, &validationError):
-				app.errorResponse(w, http.StatusUnprocessableEntity, validationError)
+				app.errorResponse(w, r, http.StatusUnprocessableEntity, validationError)
+			case errors.As(err, &reqErr):
+				app.errorResponse(w, r, reqErr.status, reqErr.message)
 			default:
-				app.serverErrorResponse(w, "handled unexpected error", err)
+				app.serverErrorResponse(w, r, "handled unexpected error", err)
 			}
 		}
 	}
 }
-func (app *application) readJSON(r *http.Request, dst any) error {
-	err := json.NewDecoder(r.Body).Decode(dst)
+
+// Client error reading a request, reported by handle with status
+type requestError struct {
+	status  int
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+func badRequest(format string, a ...any) error {
+	return &requestError{http.StatusBadRequest, fmt.Sprintf(format, a...)}
+}
+
+func bodyTooLarge(limit int64) error {
+	return &requestError{http.StatusRequestEntityTooLarge, fmt.Sprintf("body must not be larger than %d bytes", limit)}
+}
+
+// Decode a single JSON value with only known fields from a body of at
+// most max_body_size bytes. Malformed requests are reported as a
+// *requestError.
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	return app.decodeJSON(w, r, dst, true)
+}
+
+// Like readJSON, but for webhooks from third parties which don't
+// control their Content-Type and may add fields at any time. Any media
+// type is accepted and unknown fields are ignored.
+func (app *application) readWebhookJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	return app.decodeJSON(w, r, dst, false)
+}
+
+func (app *application) decodeJSON(w http.ResponseWriter, r *http.Request, dst any, strict bool) error {
+	if strict {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType != "application/json" {
+			return &requestError{http.StatusUnsupportedMediaType, "body must be application/json"}
+		}
+	}
+
+	maxBytes := app.config().maxBodySize
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	dec := json.NewDecoder(r.Body)
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+
+	err := dec.Decode(dst)
 	if err != nil {
+		field, isUnknownField := unknownField(err)
+
 		var syntaxError *json.SyntaxError
 		var unmarshalTypeError *json.UnmarshalTypeError
 		var invalidUnmarshalError *json.InvalidUnmarshalError
+		var maxBytesError *http.MaxBytesError
 		switch {
 		case errors.As(err, &syntaxError):
-			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+			return badRequest("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
 
 		case errors.Is(err, io.ErrUnexpectedEOF):
-			return errors.New("body contains badly-formed JSON")
+			return badRequest("body contains badly-formed JSON")
 
 		case errors.As(err, &unmarshalTypeError):
 			if unmarshalTypeError.Field != "" {
-				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+				return badRequest("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
 			}
-			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
+			return badRequest("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
 
 		case errors.Is(err, io.EOF):
-			return errors.New("body must not be empty")
+			return badRequest("body must not be empty")
+
+		case isUnknownField:
+			return badRequest("body contains unknown field %s", field)
+
+		case errors.As(err, &maxBytesError):
+			return bodyTooLarge(maxBytesError.Limit)
 
 		case errors.As(err, &invalidUnmarshalError):
 			panic(err)
@@ -66,9 +132,27 @@ func (app *application) readJSON(r *http.Request, dst any) error {
 		}
 	}
 
+	// Anything after the value, even another value, is an error
+	err = dec.Decode(&struct{}{})
+	if !errors.Is(err, io.EOF) {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return bodyTooLarge(maxBytesError.Limit)
+		}
+
+		return badRequest("body must only contain a single JSON value")
+	}
+
 	return nil
 }
 
+// Quoted field name from a Decoder.DisallowUnknownFields error, which
+// has no sentinel or type of its own, so only the message identifies
+// it. TestUnknownField pins the message.
+func unknownField(err error) (string, bool) {
+	return strings.CutPrefix(err.Error(), "json: unknown field ")
+}
+
 func (app *application) writeJSON(w http.ResponseWriter, statusCode int, data envelope, headers http.Header) error {
 	js, err := json.MarshalIndent(data, "", "\t")
 	if err != nil {
@@ -89,37 +173,40 @@ func (app *application) writeJSON(w http.ResponseWriter, statusCode int, data en
 }
 
 func (app *application) writeError(w http.ResponseWriter, statusCode int, message any) error {
-	if message == nil {
-		message = http.StatusText(statusCode)
-	}
-
-	data := envelope{"error": message}
+	return app.writeJSON(w, statusCode, errorEnvelope(w, statusCode, message), nil)
+}
 
-	return app.writeJSON(w, statusCode, data, nil)
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, statusCode int, message any) {
+	err := app.writeJSON(w, statusCode, errorEnvelope(w, statusCode, message), nil)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), "unable to write error response", slog.Any("err", err))
+		w.WriteHeader(http.StatusInternalServerError)
+	}
 }
 
-func (app *application) errorResponse(w http.ResponseWriter, statusCode int, message any) {
+// Error envelope including the request ID, which the requestID
+// middleware has already set on the response, so clients can quote it.
+func errorEnvelope(w http.ResponseWriter, statusCode int, message any) envelope {
 	if message == nil {
 		message = http.StatusText(statusCode)
 	}
 
 	data := envelope{"error": message}
-
-	err := app.writeJSON(w, statusCode, data, nil)
-	if err != nil {
-		app.logger.Error("unable to write error response", slog.Any("err", err))
-		w.WriteHeader(http.StatusInternalServerError)
+	if id := w.Header().Get(requestIDHeader); id != "" {
+		data["request_id"] = id
 	}
+
+	return data
 }
 
-func (app *application) serverErrorResponse(w http.ResponseWriter, logMsg string, err error) {
-	app.logger.Error(logMsg, slog.Any("err", err), slog.String("type", fmt.Sprintf("%T", err)))
+func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, logMsg string, err error) {
+	app.logger.ErrorContext(r.Context(), logMsg, slog.Any("err", err), slog.String("type", fmt.Sprintf("%T", err)))
 
-	app.errorResponse(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	app.errorResponse(w, r, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 }
 
-func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter) {
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
 
-	app.errorResponse(w, http.StatusUnauthorized, InvalidAuthenticationTokenMessage)
+	app.errorResponse(w, r, http.StatusUnauthorized, InvalidAuthenticationTokenMessage)
 }
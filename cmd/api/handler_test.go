@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadJSON(t *testing.T) {
+	app, _ := newTestApplication(t)
+
+	cfg := app.config()
+	cfg.maxBodySize = 64
+	app.setConfig(cfg)
+
+	h := app.handle(func(w http.ResponseWriter, r *http.Request) error {
+		var input struct {
+			Email string `json:"email"`
+		}
+
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			return err
+		}
+
+		return app.writeJSON(w, http.StatusOK, envelope{"email": input.Email}, nil)
+	})
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		error       string
+	}{
+		{"valid", "application/json", `{"email": "jane@example.com"}`, http.StatusOK, ""},
+		{"charset", "application/json; charset=utf-8", `{"email": "jane@example.com"}`, http.StatusOK, ""},
+		{"trailing whitespace", "application/json", "{}\n", http.StatusOK, ""},
+		{"missing content type", "", `{}`, http.StatusUnsupportedMediaType, "body must be application/json"},
+		{"wrong content type", "text/plain", `{}`, http.StatusUnsupportedMediaType, "body must be application/json"},
+		{"empty", "application/json", ``, http.StatusBadRequest, "body must not be empty"},
+		{"malformed", "application/json", `{"email": }`, http.StatusBadRequest, "body contains badly-formed JSON (at character 11)"},
+		{"wrong type", "application/json", `{"email": 1}`, http.StatusBadRequest, `body contains incorrect JSON type for field "email"`},
+		{"unknown field", "application/json", `{"emial": "jane@example.com"}`, http.StatusBadRequest, `body contains unknown field "emial"`},
+		{"multiple values", "application/json", `{} {}`, http.StatusBadRequest, "body must only contain a single JSON value"},
+		{"trailing garbage", "application/json", `{} x`, http.StatusBadRequest, "body must only contain a single JSON value"},
+		{"too large", "application/json", `{"email": "` + strings.Repeat("a", 64) + `"}`, http.StatusRequestEntityTooLarge, "body must not be larger than 64 bytes"},
+		{"too large after value", "application/json", `{}` + strings.Repeat(" ", 64), http.StatusRequestEntityTooLarge, "body must not be larger than 64 bytes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, r)
+
+			if rr.Code != tt.status {
+				t.Fatalf("got %d %s; want %d", rr.Code, rr.Body, tt.status)
+			}
+
+			var env map[string]any
+			err := json.NewDecoder(rr.Body).Decode(&env)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.error != "" && env["error"] != tt.error {
+				t.Errorf("error: got %q; want %q", env["error"], tt.error)
+			}
+		})
+	}
+}
+
+// Pins the encoding/json message unknownField relies on
+func TestUnknownField(t *testing.T) {
+	dec := json.NewDecoder(strings.NewReader(`{"emial": "jane@example.com"}`))
+	dec.DisallowUnknownFields()
+
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := dec.Decode(&input)
+	if err == nil {
+		t.Fatal("decoded unknown field")
+	}
+
+	field, ok := unknownField(err)
+	if !ok || field != `"emial"` {
+		t.Errorf("got %q, %t from %q; want %q, true", field, ok, err, `"emial"`)
+	}
+
+	if _, ok := unknownField(errors.New("json: cannot unmarshal")); ok {
+		t.Error("matched other error")
+	}
+}
+
+func TestReadWebhookJSON(t *testing.T) {
+	app, _ := newTestApplication(t)
+
+	cfg := app.config()
+	cfg.maxBodySize = 64
+	app.setConfig(cfg)
+
+	h := app.handle(func(w http.ResponseWriter, r *http.Request) error {
+		var input struct {
+			Email string `json:"email"`
+		}
+
+		err := app.readWebhookJSON(w, r, &input)
+		if err != nil {
+			return err
+		}
+
+		return app.writeJSON(w, http.StatusOK, envelope{"email": input.Email}, nil)
+	})
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"valid", "application/json", `{"email": "jane@example.com"}`, http.StatusOK},
+		{"any content type", "text/plain", `{"email": "jane@example.com"}`, http.StatusOK},
+		{"missing content type", "", `{"email": "jane@example.com"}`, http.StatusOK},
+		{"unknown field", "application/json", `{"email": "jane@example.com", "id": 1}`, http.StatusOK},
+		{"malformed", "application/json", `{"email": }`, http.StatusBadRequest},
+		{"too large", "application/json", `{"email": "` + strings.Repeat("a", 64) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, r)
+
+			if rr.Code != tt.status {
+				t.Fatalf("got %d %s; want %d", rr.Code, rr.Body, tt.status)
+			}
+		})
+	}
+}
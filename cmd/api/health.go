@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+type dependencyStatus struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Liveness only reports that the process is serving requests.
+func (app *application) healthLive(w http.ResponseWriter, r *http.Request) error {
+	return app.writeJSON(w, http.StatusOK, envelope{"status": "alive"}, nil)
+}
+
+// Readiness reports whether the server can handle traffic: every
+// dependency is reachable and the server isn't shutting down.
+func (app *application) healthReady(w http.ResponseWriter, r *http.Request) error {
+	checks := map[string]func(ctx context.Context) error{
+		"database": app.pingDatabase,
+		"mail":     app.mailer.Ping,
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		statuses = make(map[string]dependencyStatus, len(checks))
+		ready    = true
+	)
+
+	for name, check := range checks {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check(ctx)
+
+			s := dependencyStatus{
+				Status:    "up",
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				// Errors may reveal hosts and addresses, keep them in the log
+				s.Status = "down"
+				s.Error = "unavailable"
+				app.logger.WarnContext(r.Context(), "health check failed",
+					slog.String("check", name), slog.Any("err", err))
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			statuses[name] = s
+			if err != nil {
+				ready = false
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	status := "ready"
+	switch {
+	case app.shuttingDown.Load():
+		status = "shutting_down"
+	case !ready:
+		status = "not_ready"
+	}
+
+	statusCode := http.StatusOK
+	if status != "ready" {
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	data := envelope{
+		"status": status,
+		"checks": statuses,
+	}
+
+	return app.writeJSON(w, statusCode, data, nil)
+}
+
+func (app *application) pingDatabase(ctx context.Context) error {
+	return app.pool.Ping(ctx)
+}
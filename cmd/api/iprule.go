@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/gofrs/uuid/v5"
+	"github.com/micahco/api/internal/data"
+	"github.com/micahco/api/internal/ratelimit"
+)
+
+const banReason = "rate limit exceeded repeatedly"
+
+// How often the IP rule snapshot is reloaded to pick up rules added or
+// removed by other servers.
+const ipRuleRefreshInterval = 10 * time.Second
+
+// Snapshot of the active IP rules, so requests are filtered without a
+// query each. It is loaded at startup and by watchIPRules.
+type ipRuleSnapshot struct {
+	mu    sync.RWMutex
+	rules []data.IPRule
+}
+
+// Reload the IP rule snapshot from the database.
+func (app *application) refreshIPRules(ctx context.Context) error {
+	rules, err := app.models.IPRule.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	snapshot := make([]data.IPRule, len(rules))
+	for i, rule := range rules {
+		snapshot[i] = *rule
+	}
+
+	app.ipRules.mu.Lock()
+	defer app.ipRules.mu.Unlock()
+
+	app.ipRules.rules = snapshot
+
+	return nil
+}
+
+// Reload the IP rule snapshot after a change, so it applies to the
+// next request. On failure the change still applies after the next
+// periodic refresh.
+func (app *application) ipRulesChanged(ctx context.Context) {
+	err := app.refreshIPRules(ctx)
+	if err != nil {
+		app.logger.ErrorContext(ctx, "unable to refresh ip rules", slog.Any("err", err))
+	}
+}
+
+// Rule from the snapshot which applies to addr.
+func (app *application) matchIPRule(addr netip.Addr) (data.IPRule, bool) {
+	app.ipRules.mu.RLock()
+	defer app.ipRules.mu.RUnlock()
+
+	return data.BestIPRule(app.ipRules.rules, addr, time.Now())
+}
+
+// Reject requests from networks denied by an IP rule. Must run after
+// clientIP.
+func (app *application) ipFilter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr := contextClientIP(r.Context())
+		if !addr.IsValid() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rule, ok := app.matchIPRule(addr)
+		if ok && rule.Action == data.IPRuleDeny {
+			if rule.ExpiresAt != nil {
+				retry := (time.Until(*rule.ExpiresAt) + time.Second - 1) / time.Second
+				w.Header().Set("Retry-After", strconv.Itoa(max(int(retry), 1)))
+			}
+
+			app.prom.ipRuleRejected()
+			app.errorResponse(w, r, http.StatusForbidden, IPBlockedMessage)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Count a rate limit rejection against the client address, and ban
+// the address for ban.duration once it has been rejected more than
+// ban.threshold times within ban.window. Addresses covered by an allow
+// rule are never banned.
+func (app *application) countRejection(r *http.Request) error {
+	ban := app.config().ban
+
+	addr := contextClientIP(r.Context())
+	if ban.threshold == 0 || !addr.IsValid() {
+		return nil
+	}
+
+	limit := ratelimit.Limit{
+		Rate:  float64(ban.threshold) / ban.window.Seconds(),
+		Burst: ban.threshold,
+	}
+
+	res, err := app.limiter.Allow(r.Context(), "ban:ip:"+addr.String(), limit)
+	if err != nil || res.Allowed {
+		return err
+	}
+
+	rule, ok := app.matchIPRule(addr)
+	if ok && rule.Action == data.IPRuleAllow {
+		return nil
+	}
+
+	expiresAt := time.Now().Add(ban.duration)
+	deny := &data.IPRule{
+		Network:   netip.PrefixFrom(addr, addr.BitLen()),
+		Action:    data.IPRuleDeny,
+		Reason:    banReason,
+		ExpiresAt: &expiresAt,
+	}
+
+	err = app.models.IPRule.Insert(r.Context(), deny)
+	if err != nil {
+		return err
+	}
+	app.ipRulesChanged(r.Context())
+
+	app.prom.ipBanned()
+	app.logger.WarnContext(r.Context(), "banned ip address",
+		slog.String("network", deny.Network.String()),
+		slog.Time("expires_at", expiresAt))
+
+	return nil
+}
+
+// Delete expired IP rules every interval until ctx is done.
+func (app *application) purgeIPRules(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := app.models.IPRule.Purge(ctx)
+			if err != nil && ctx.Err() == nil {
+				app.logger.Error("unable to purge ip rules", slog.Any("err", err))
+			}
+		}
+	}
+}
+
+// Reload the IP rule snapshot every interval until ctx is done. The
+// previous snapshot is kept if reloading fails.
+func (app *application) watchIPRules(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := app.refreshIPRules(ctx)
+			if err != nil && ctx.Err() == nil {
+				app.logger.Error("unable to refresh ip rules", slog.Any("err", err))
+			}
+		}
+	}
+}
+
+func (app *application) adminIPRulesGet(w http.ResponseWriter, r *http.Request) error {
+	rules, err := app.models.IPRule.GetAll(r.Context())
+	if err != nil {
+		return err
+	}
+
+	return app.writeJSON(w, http.StatusOK, envelope{"rules": rules}, nil)
+}
+
+// Allow or deny a network, replacing any existing rule for it.
+func (app *application) adminIPRulesPost(w http.ResponseWriter, r *http.Request) error {
+	var input struct {
+		Network   string     `json:"network"`
+		Action    string     `json:"action"`
+		Reason    string     `json:"reason"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		return err
+	}
+
+	err = validation.ValidateStruct(&input,
+		validation.Field(&input.Network, validation.Required),
+	)
+	if err != nil {
+		return err
+	}
+
+	network, err := data.ParseNetwork(input.Network)
+	if err != nil {
+		return validation.Errors{"network": errors.New("must be an IP address or CIDR")}
+	}
+
+	rule := &data.IPRule{
+		Network:   network,
+		Action:    input.Action,
+		Reason:    input.Reason,
+		ExpiresAt: input.ExpiresAt,
+	}
+
+	err = app.models.IPRule.Insert(r.Context(), rule)
+	if err != nil {
+		return err
+	}
+	app.ipRulesChanged(r.Context())
+
+	return app.writeJSON(w, http.StatusCreated, envelope{"rule": rule}, nil)
+}
+
+// Delete a rule, lifting a ban.
+func (app *application) adminIPRulesDelete(w http.ResponseWriter, r *http.Request) error {
+	id, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		return app.writeError(w, http.StatusNotFound, nil)
+	}
+
+	err = app.models.IPRule.Delete(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return app.writeError(w, http.StatusNotFound, nil)
+		default:
+			return err
+		}
+	}
+	app.ipRulesChanged(r.Context())
+
+	msg := envelope{"message": "Rule removed."}
+
+	return app.writeJSON(w, http.StatusOK, msg, nil)
+}
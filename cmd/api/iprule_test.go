@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/micahco/api/internal/data"
+)
+
+// Send a request on behalf of client through the trusted proxy.
+func getFrom(t *testing.T, ts *testServer, path, client string) *http.Response {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+	req.Header.Set("X-Forwarded-For", client)
+
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	return res
+}
+
+func insertIPRule(t *testing.T, app *application, network, action string) {
+	t.Helper()
+
+	rule := &data.IPRule{
+		Network: netip.MustParsePrefix(network),
+		Action:  action,
+	}
+
+	err := app.models.IPRule.Insert(context.Background(), rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.ipRulesChanged(context.Background())
+
+	t.Cleanup(func() {
+		app.models.IPRule.Delete(context.Background(), rule.ID)
+		app.ipRulesChanged(context.Background())
+	})
+}
+
+func TestIPFilter(t *testing.T) {
+	app, _ := newTestApplication(t)
+
+	cfg := app.config()
+	cfg.trustedProxyCIDRs = "127.0.0.1"
+	app.setConfig(cfg)
+
+	insertIPRule(t, app, "192.0.2.0/24", data.IPRuleDeny)
+	insertIPRule(t, app, "192.0.2.10/32", data.IPRuleAllow)
+
+	ts := newTestServer(t, app)
+
+	tests := []struct {
+		client string
+		want   int
+	}{
+		{"192.0.2.1", http.StatusForbidden},
+		{"192.0.2.10", http.StatusOK},
+		{"198.51.100.1", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		res := getFrom(t, ts, "/api/v1/healthcheck", tt.client)
+		if res.StatusCode != tt.want {
+			t.Errorf("%s: got %d; want %d", tt.client, res.StatusCode, tt.want)
+		}
+		if tt.want == http.StatusForbidden && res.Header.Get("Retry-After") != "" {
+			t.Errorf("%s: Retry-After set for permanent rule", tt.client)
+		}
+	}
+}
+
+func TestAutoBan(t *testing.T) {
+	app, _ := newTestApplication(t)
+
+	cfg := app.config()
+	cfg.trustedProxyCIDRs = "127.0.0.1"
+	cfg.limiter.enabled = true
+	cfg.limiter.rps = 0.1
+	cfg.limiter.burst = 3
+	cfg.ban.threshold = 2
+	cfg.ban.window = time.Minute
+	cfg.ban.duration = 10 * time.Minute
+	app.setConfig(cfg)
+
+	ts := newTestServer(t, app)
+
+	const client = "203.0.113.7"
+
+	// Three allowed, then banned on the third rejection
+	want := []int{200, 200, 200, 429, 429, 429, 403}
+	for i, status := range want {
+		res := getFrom(t, ts, "/api/v1/healthcheck", client)
+		if res.StatusCode != status {
+			t.Fatalf("request %d: got %d; want %d", i+1, res.StatusCode, status)
+		}
+		if status == http.StatusForbidden {
+			retry, _ := strconv.Atoi(res.Header.Get("Retry-After"))
+			if retry < 590 || retry > 600 {
+				t.Errorf("Retry-After: got %q", res.Header.Get("Retry-After"))
+			}
+		}
+	}
+
+	if res := getFrom(t, ts, "/api/v1/healthcheck", "203.0.113.8"); res.StatusCode != http.StatusOK {
+		t.Errorf("other client: got %d", res.StatusCode)
+	}
+
+	admin := newTestAdmin(t, app)
+
+	status, env := ts.request(t, http.MethodGet, "/api/v1/admin/ip-rules", admin, nil)
+	if status != http.StatusOK {
+		t.Fatalf("list rules: got %d %v", status, env)
+	}
+
+	var id string
+	for _, r := range env["rules"].([]any) {
+		rule := r.(map[string]any)
+		if rule["network"] == client+"/32" {
+			id = rule["id"].(string)
+			if rule["action"] != data.IPRuleDeny || rule["reason"] != banReason || rule["expires_at"] == nil {
+				t.Errorf("ban rule: %v", rule)
+			}
+		}
+	}
+	if id == "" {
+		t.Fatalf("no ban for %s in %v", client, env)
+	}
+
+	status, env = ts.request(t, http.MethodDelete, "/api/v1/admin/ip-rules/"+id, admin, nil)
+	if status != http.StatusOK {
+		t.Fatalf("lift ban: got %d %v", status, env)
+	}
+
+	// Still over the rate limit, but no longer banned
+	if res := getFrom(t, ts, "/api/v1/healthcheck", client); res.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("after lifting ban: got %d", res.StatusCode)
+	}
+
+	status, _ = ts.request(t, http.MethodDelete, "/api/v1/admin/ip-rules/"+id, admin, nil)
+	if status != http.StatusNotFound {
+		t.Errorf("lift missing ban: got %d", status)
+	}
+}
+
+// IP rule store counting the queries made to filter requests.
+type countingIPRuleStore struct {
+	data.IPRuleStore
+	queries atomic.Int32
+}
+
+func (s *countingIPRuleStore) Match(ctx context.Context, addr netip.Addr) (*data.IPRule, error) {
+	s.queries.Add(1)
+	return s.IPRuleStore.Match(ctx, addr)
+}
+
+func (s *countingIPRuleStore) GetAll(ctx context.Context) ([]*data.IPRule, error) {
+	s.queries.Add(1)
+	return s.IPRuleStore.GetAll(ctx)
+}
+
+func TestIPRuleSnapshot(t *testing.T) {
+	app, _ := newTestApplication(t)
+
+	store := &countingIPRuleStore{IPRuleStore: app.models.IPRule}
+	app.models.IPRule = store
+
+	cfg := app.config()
+	cfg.trustedProxyCIDRs = "127.0.0.1"
+	cfg.limiter.enabled = true
+	cfg.limiter.rps = 0.1
+	cfg.limiter.burst = 1
+	cfg.ban.threshold = 1
+	cfg.ban.window = time.Minute
+	cfg.ban.duration = 10 * time.Minute
+	app.setConfig(cfg)
+
+	ts := newTestServer(t, app)
+
+	const client = "203.0.113.9"
+
+	// Reloaded once the client is banned, never to filter a request
+	want := []int{200, 429, 429, 403, 403, 403}
+	for i, status := range want {
+		res := getFrom(t, ts, "/api/v1/healthcheck", client)
+		if res.StatusCode != status {
+			t.Fatalf("request %d: got %d; want %d", i+1, res.StatusCode, status)
+		}
+	}
+
+	if n := store.queries.Load(); n != 1 {
+		t.Errorf("made %d queries for %d requests; want 1", n, len(want))
+	}
+}
+
+func TestAdminIPRulesPost(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	admin := newTestAdmin(t, app)
+
+	tests := []struct {
+		name   string
+		input  map[string]any
+		status int
+	}{
+		{"address", map[string]any{"network": "198.51.100.7", "action": "deny"}, http.StatusCreated},
+		{"network", map[string]any{"network": "198.51.100.0/24", "action": "allow"}, http.StatusCreated},
+		{"invalid network", map[string]any{"network": "198.51.100.0/33", "action": "deny"}, http.StatusUnprocessableEntity},
+		{"invalid action", map[string]any{"network": "198.51.100.7", "action": "block"}, http.StatusUnprocessableEntity},
+		{"expired", map[string]any{"network": "198.51.100.7", "action": "deny", "expires_at": "2000-01-01T00:00:00Z"}, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, env := ts.request(t, http.MethodPost, "/api/v1/admin/ip-rules", admin, tt.input)
+			if status != tt.status {
+				t.Fatalf("got %d %v; want %d", status, env, tt.status)
+			}
+
+			if rule, ok := env["rule"].(map[string]any); ok {
+				id := rule["id"].(string)
+				t.Cleanup(func() {
+					ts.request(t, http.MethodDelete, "/api/v1/admin/ip-rules/"+id, admin, nil)
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Adds the request ID and client address from the context to every
+// record, so logging with the request context can be correlated with
+// the access log.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := contextGetRequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if addr := contextClientIP(ctx); addr.IsValid() {
+		r.AddAttrs(slog.String("client_ip", addr.String()))
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
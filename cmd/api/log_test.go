@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestRequestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	app := &application{
+		logger: slog.New(contextHandler{slog.NewJSONHandler(&buf, nil)}),
+	}
+
+	r := chi.NewRouter()
+	r.Use(app.requestID)
+	r.Use(app.accessLog)
+	r.Get("/things/{id}", func(w http.ResponseWriter, r *http.Request) {
+		app.serverErrorResponse(w, r, "failed", errors.New("boom"))
+	})
+
+	t.Run("incoming id", func(t *testing.T) {
+		buf.Reset()
+
+		req := httptest.NewRequest(http.MethodGet, "/things/1", nil)
+		req.Header.Set("X-Request-ID", "abc-123")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		if got := rr.Header().Get("X-Request-ID"); got != "abc-123" {
+			t.Errorf("response header = %q", got)
+		}
+
+		var body map[string]any
+		json.NewDecoder(rr.Body).Decode(&body)
+		if body["request_id"] != "abc-123" {
+			t.Errorf("envelope request_id = %v", body["request_id"])
+		}
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("got %d log lines, want 2:\n%s", len(lines), buf.String())
+		}
+
+		for _, line := range lines {
+			var rec map[string]any
+			json.Unmarshal([]byte(line), &rec)
+			if rec["request_id"] != "abc-123" {
+				t.Errorf("log line missing request_id: %s", line)
+			}
+		}
+
+		var access map[string]any
+		json.Unmarshal([]byte(lines[1]), &access)
+		if access["route"] != "/things/{id}" || access["status"] != float64(500) || access["method"] != "GET" {
+			t.Errorf("unexpected access log: %s", lines[1])
+		}
+	})
+
+	t.Run("generated id", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/things/1", nil)
+		req.Header.Set("X-Request-ID", "bad id\n")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		got := rr.Header().Get("X-Request-ID")
+		if got == "" || got == "bad id\n" {
+			t.Errorf("response header = %q, want generated id", got)
+		}
+	})
+}
+
+func TestBackgroundLogging(t *testing.T) {
+	var buf bytes.Buffer
+	app := &application{
+		logger: slog.New(contextHandler{slog.NewJSONHandler(&buf, nil)}),
+	}
+
+	r := chi.NewRouter()
+	r.Use(app.requestID)
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		app.background(r.Context(), func(ctx context.Context) error {
+			return errors.New("boom")
+		})
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	// The request has finished, but the task still logs its ID
+	app.wg.Wait()
+
+	var rec map[string]any
+	json.Unmarshal(buf.Bytes(), &rec)
+	if rec["msg"] != "background process returned error" || rec["request_id"] != "abc-123" {
+		t.Errorf("unexpected log line: %s", buf.String())
+	}
+}
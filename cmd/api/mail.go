@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-ozzo/ozzo-validation/is"
+	"github.com/micahco/api/internal/data"
+	"github.com/micahco/api/internal/mailer"
+)
+
+// Send log for the mailer, converting its limits so data doesn't
+// depend on mailer.
+type sendLog struct {
+	data.MailSendModel
+}
+
+var _ mailer.SendLog = sendLog{}
+
+func (l sendLog) InsertWithinLimits(ctx context.Context, recipient, domain string, limits []mailer.SendLimit) (bool, error) {
+	dataLimits := make([]data.SendLimit, len(limits))
+	for i, limit := range limits {
+		dataLimits[i] = data.SendLimit(limit)
+	}
+
+	return l.MailSendModel.InsertWithinLimits(ctx, recipient, domain, dataLimits)
+}
+
+const (
+	mailEventBounce    = "bounce"
+	mailEventComplaint = "complaint"
+)
+
+// Receive a bounce or complaint notification from the mail provider.
+// Permanent bounces and complaints add the recipient to the
+// suppression list so no further mail is sent to that address.
+func (app *application) mailEventsPost(w http.ResponseWriter, r *http.Request) error {
+	// Webhook is disabled unless a shared secret is configured
+	if app.config().mail.webhookSecret == "" {
+		return app.writeError(w, http.StatusNotFound, nil)
+	}
+
+	secret := r.Header.Get("X-Webhook-Secret")
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(app.config().mail.webhookSecret)) != 1 {
+		return app.writeError(w, http.StatusUnauthorized, nil)
+	}
+
+	var input struct {
+		Type      string `json:"type"`
+		Email     string `json:"email"`
+		Permanent bool   `json:"permanent"`
+		Detail    string `json:"detail"`
+	}
+
+	err := app.readWebhookJSON(w, r, &input)
+	if err != nil {
+		return err
+	}
+
+	err = validation.ValidateStruct(&input,
+		validation.Field(&input.Type, validation.Required, validation.In(mailEventBounce, mailEventComplaint)),
+		validation.Field(&input.Email, validation.Required, is.Email),
+	)
+	if err != nil {
+		return err
+	}
+
+	// Soft bounces are transient, keep mailing the address
+	if input.Type == mailEventBounce && !input.Permanent {
+		return app.writeJSON(w, http.StatusOK, envelope{"suppressed": false}, nil)
+	}
+
+	reason := data.SuppressionReasonBounce
+	if input.Type == mailEventComplaint {
+		reason = data.SuppressionReasonComplaint
+	}
+
+	err = app.models.MailSuppression.Insert(r.Context(), &data.MailSuppression{
+		Email:  input.Email,
+		Reason: reason,
+		Detail: input.Detail,
+	})
+	if err != nil {
+		return err
+	}
+
+	return app.writeJSON(w, http.StatusOK, envelope{"suppressed": true}, nil)
+}
+
+func (app *application) adminMailSuppressionsGet(w http.ResponseWriter, r *http.Request) error {
+	suppressions, err := app.models.MailSuppression.GetAll(r.Context())
+	if err != nil {
+		return err
+	}
+
+	return app.writeJSON(w, http.StatusOK, envelope{"suppressions": suppressions}, nil)
+}
+
+func (app *application) adminMailSuppressionsDelete(w http.ResponseWriter, r *http.Request) error {
+	email, err := url.PathUnescape(chi.URLParam(r, "email"))
+	if err != nil {
+		return app.writeError(w, http.StatusNotFound, nil)
+	}
+
+	err = app.models.MailSuppression.Delete(r.Context(), email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return app.writeError(w, http.StatusNotFound, nil)
+		default:
+			return err
+		}
+	}
+
+	msg := envelope{"message": "Suppression removed."}
+
+	return app.writeJSON(w, http.StatusOK, msg, nil)
+}
@@ -2,13 +2,13 @@ package main
 
 import (
 	"context"
+	"crypto"
 	"expvar"
 	"flag"
 	"fmt"
 	"log/slog"
 	"net/mail"
 	"os"
-	"runtime"
 	"time"
 
 	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
@@ -17,6 +17,9 @@ import (
 	"github.com/lmittmann/tint"
 	"github.com/micahco/api/internal/data"
 	"github.com/micahco/api/internal/mailer"
+	"github.com/micahco/api/internal/migrate"
+	"github.com/micahco/api/internal/ratelimit"
+	"github.com/micahco/api/migrations"
 	"github.com/micahco/api/ui"
 )
 
@@ -25,63 +28,53 @@ var (
 	version   string
 )
 
-type config struct {
-	baseURL string
-	port    int
-	dev     bool
-	db      struct {
-		dsn string
-	}
-	limiter struct {
-		rps     float64
-		burst   int
-		enabled bool
-	}
-	smtp struct {
-		host     string
-		port     int
-		username string
-		password string
-		sender   string
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(migrateCmd(os.Args[2:], os.Stdout, os.Stderr))
 	}
-}
 
-func main() {
 	var cfg config
+	var opts options
 
-	// Default flag values for production
-	flag.StringVar(&cfg.baseURL, "base-url", "http://127.0.0.1:5173", "Base URL")
-	flag.IntVar(&cfg.port, "port", 8080, "API server port")
-	flag.BoolVar(&cfg.dev, "dev", false, "Development mode")
-
-	flag.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
+	fs := newFlagSet(os.Args[0], flag.ExitOnError, &cfg, &opts)
 
-	flag.StringVar(&cfg.smtp.host, "smtp-host", "", "SMTP host")
-	flag.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
-	flag.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
-	flag.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
-	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "", "SMTP sender")
-
-	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
-	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
-	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
-
-	displayVersion := flag.Bool("version", false, "Display version and exit")
-
-	flag.Parse()
+	err := loadConfig(fs, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	if *displayVersion {
+	if opts.version {
 		fmt.Printf("Version:\t%s\n", version)
 		fmt.Printf("Build time:\t%s\n", buildTime)
 		os.Exit(0)
 	}
 
+	if opts.printConfig {
+		printConfig(os.Stdout, fs)
+		os.Exit(0)
+	}
+
+	err = cfg.validate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%s\n", err)
+		os.Exit(2)
+	}
+
 	// Logger
-	h := newSlogHandler(cfg)
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(cfg.slogLevel())
+	h := contextHandler{newSlogHandler(cfg, logLevel)}
 	logger := slog.New(h)
 	// Create error log for http.Server
 	errLog := slog.NewLogLogger(h, slog.LevelError)
 
+	// Tracing
+	shutdownTracing, err := setupTracing(cfg)
+	if err != nil {
+		fatal(logger, err)
+	}
+
 	// PostgreSQL
 	pool, err := openPool(cfg.db.dsn)
 	if err != nil {
@@ -89,11 +82,57 @@ func main() {
 	}
 	defer pool.Close()
 
+	// Database schema
+	migrator, err := migrate.New(pool, migrations.Files)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	if cfg.migrateOnStart {
+		applied, err := migrateOnStart(migrator, time.Minute)
+		if err != nil {
+			fatal(logger, err)
+		}
+
+		for _, m := range applied {
+			logger.Info("applied migration", slog.Uint64("version", uint64(m.Version)), slog.String("name", m.Name))
+		}
+	}
+
+	// Refuse to serve requests against a database missing tables
+	// or columns, in development a warning is enough.
+	err = checkSchema(migrator)
+	if err != nil {
+		if !cfg.dev {
+			fatal(logger, err)
+		}
+
+		logger.Warn("database schema is out of date, run `api migrate up`", slog.Any("err", err))
+	}
+
 	// Mailer
-	sender := &mail.Address{
-		Name:    "Do Not Reply",
-		Address: cfg.smtp.sender,
+	var dkimKey crypto.Signer
+	if cfg.dkim.keyFile != "" {
+		dkimKey, err = mailer.LoadDKIMKey(cfg.dkim.keyFile)
+		if err != nil {
+			fatal(logger, err)
+		}
+	}
+
+	// Per-recipient and per-domain send budgets
+	sendLimits := []mailer.SendLimit{
+		{
+			Window:    time.Hour,
+			Recipient: cfg.mail.recipientPerHour,
+			Domain:    cfg.mail.domainPerHour,
+		},
+		{
+			Window:    24 * time.Hour,
+			Recipient: cfg.mail.recipientPerDay,
+			Domain:    cfg.mail.domainPerDay,
+		},
 	}
+
 	logger.Info("dialing SMTP server...")
 	mailer, err := mailer.New(
 		cfg.dev,
@@ -101,7 +140,7 @@ func main() {
 		cfg.smtp.port,
 		cfg.smtp.username,
 		cfg.smtp.password,
-		sender,
+		mailSender(cfg),
 		ui.Files,
 		"mail/*.tmpl",
 	)
@@ -109,25 +148,73 @@ func main() {
 		fatal(logger, err)
 	}
 
+	// DKIM signing is optional and enabled by providing a key
+	if dkimKey != nil {
+		mailer.EnableDKIM(cfg.dkim.domain, cfg.dkim.selector, dkimKey)
+	}
+
+	models := data.New(pool)
+	mailer.SetSuppressionList(models.MailSuppression)
+	mailer.SetSendLimits(sendLog{models.MailSend}, sendLimits...)
+
+	// Rate limiter state is kept in memory unless shared by replicas
+	var limiter ratelimit.Store = ratelimit.NewMemory(3 * time.Minute)
+	if cfg.limiter.store == "postgres" {
+		limiter = models.RateLimit
+	}
+
+	// Metrics
+	prom := newMetrics(pool)
+	mailer.SetObserver(prom.mailSent)
+
 	expvar.NewString("version").Set(version)
-	expvar.Publish("goroutines", expvar.Func(func() interface{} {
-		return runtime.NumGoroutine()
-	}))
-	expvar.Publish("database", expvar.Func(func() interface{} {
-		return dbStats(pool.Stat())
-	}))
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		mailer: mailer,
-		models: data.New(pool),
+		logger:   logger,
+		logLevel: logLevel,
+		limiter:  limiter,
+		mailer:   mailer,
+		migrator: migrator,
+		models:   models,
+		pool:     pool,
+		prom:     prom,
+		loadConfig: func() (config, error) {
+			return parseConfig(os.Args[1:], os.LookupEnv)
+		},
+	}
+	app.setConfig(cfg)
+
+	// Load IP rules before serving, so no request is filtered against
+	// an empty snapshot. Later refreshes keep it if they fail.
+	err = app.refreshIPRules(context.Background())
+	if err != nil {
+		if !cfg.dev {
+			fatal(logger, err)
+		}
+
+		logger.Warn("unable to load ip rules", slog.Any("err", err))
 	}
 
 	err = app.serve(errLog)
 	if err != nil {
 		fatal(logger, err)
 	}
+
+	// Flush spans from requests completed during shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = shutdownTracing(ctx)
+	if err != nil {
+		logger.Error("unable to flush traces", slog.Any("err", err))
+	}
+}
+
+func mailSender(cfg config) *mail.Address {
+	return &mail.Address{
+		Name:    "Do Not Reply",
+		Address: cfg.smtp.sender,
+	}
 }
 
 func openPool(dsn string) (*pgxpool.Pool, error) {
@@ -142,6 +229,7 @@ func openPool(dsn string) (*pgxpool.Pool, error) {
 		pgxuuid.Register(conn.TypeMap())
 		return nil
 	}
+	cfg.ConnConfig.Tracer = data.QueryTracer{}
 
 	dbpool, err := pgxpool.NewWithConfig(ctx, cfg)
 	if err != nil {
@@ -156,50 +244,20 @@ func openPool(dsn string) (*pgxpool.Pool, error) {
 	return dbpool, err
 }
 
-func newSlogHandler(cfg config) slog.Handler {
+func newSlogHandler(cfg config, level slog.Leveler) slog.Handler {
 	if cfg.dev {
 		// Development text hanlder
 		return tint.NewHandler(os.Stdout, &tint.Options{
 			AddSource:  true,
-			Level:      slog.LevelDebug,
+			Level:      level,
 			TimeFormat: time.Kitchen,
 		})
 	}
 
-	// Production use JSON handler with default opts
-	return slog.NewJSONHandler(os.Stdout, nil)
-}
-
-type poolStats struct {
-	AcquireCount            int64
-	AcquireDuration         time.Duration
-	AcquiredConns           int32
-	CanceledAcquireCount    int64
-	ConstructingConns       int32
-	EmptyAcquireCount       int64
-	IdleConns               int32
-	MaxConns                int32
-	MaxIdleDestroyCount     int64
-	MaxLifetimeDestroyCount int64
-	NewConnsCount           int64
-	TotalConns              int32
-}
-
-func dbStats(st *pgxpool.Stat) poolStats {
-	return poolStats{
-		AcquireCount:            st.AcquireCount(),
-		AcquireDuration:         st.AcquireDuration(),
-		AcquiredConns:           st.AcquiredConns(),
-		CanceledAcquireCount:    st.CanceledAcquireCount(),
-		ConstructingConns:       st.ConstructingConns(),
-		EmptyAcquireCount:       st.EmptyAcquireCount(),
-		IdleConns:               st.IdleConns(),
-		MaxConns:                st.MaxConns(),
-		MaxIdleDestroyCount:     st.MaxIdleDestroyCount(),
-		MaxLifetimeDestroyCount: st.MaxLifetimeDestroyCount(),
-		NewConnsCount:           st.NewConnsCount(),
-		TotalConns:              st.TotalConns(),
-	}
+	// Production use JSON handler
+	return slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: level,
+	})
 }
 
 func fatal(logger *slog.Logger, err error) {
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "api"
+
+// Prometheus metrics, all methods are safe to call on a nil receiver
+// so applications created without metrics don't need to check.
+type promMetrics struct {
+	registry         *prometheus.Registry
+	requests         *prometheus.CounterVec
+	requestDuration  *prometheus.HistogramVec
+	responses        *prometheus.CounterVec
+	responseSize     *prometheus.HistogramVec
+	requestsInFlight prometheus.Gauge
+	rateLimited      prometheus.Counter
+	rateLimitErrors  prometheus.Counter
+	ipBlocked        prometheus.Counter
+	ipBans           prometheus.Counter
+	mailSends        *prometheus.CounterVec
+	backgroundTasks  *prometheus.CounterVec
+	backgroundActive prometheus.Gauge
+}
+
+func newMetrics(pool *pgxpool.Pool) *promMetrics {
+	m := &promMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route pattern, method and status code class.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route pattern and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		responses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "total_responses_sent_by_status",
+			Help:      "HTTP responses by route pattern and status code.",
+		}, []string{"route", "status"}),
+		responseSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_response_size_bytes",
+			Help:      "HTTP response body size by route pattern.",
+			Buckets:   prometheus.ExponentialBuckets(64, 4, 8),
+		}, []string{"route"}),
+		requestsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_in_flight",
+			Help:      "HTTP requests currently being served.",
+		}),
+		rateLimited: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rate_limit_rejections_total",
+			Help:      "Requests rejected by the rate limiter.",
+		}),
+		rateLimitErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rate_limit_errors_total",
+			Help:      "Requests allowed unchecked because the rate limiter store failed.",
+		}),
+		ipBlocked: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "ip_rule_rejections_total",
+			Help:      "Requests rejected by an IP deny rule.",
+		}),
+		ipBans: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "ip_bans_total",
+			Help:      "IP addresses banned for repeatedly exceeding rate limits.",
+		}),
+		mailSends: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "mail_sends_total",
+			Help:      "Mail sends by outcome.",
+		}, []string{"outcome"}),
+		backgroundTasks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "background_tasks_total",
+			Help:      "Completed background tasks by result.",
+		}, []string{"result"}),
+		backgroundActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "background_tasks_running",
+			Help:      "Background tasks currently running.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.responses,
+		m.responseSize,
+		m.requestsInFlight,
+		m.rateLimited,
+		m.rateLimitErrors,
+		m.ipBlocked,
+		m.ipBans,
+		m.mailSends,
+		m.backgroundTasks,
+		m.backgroundActive,
+	)
+
+	if pool != nil {
+		m.registry.MustRegister(newPoolCollector(pool))
+	}
+
+	return m
+}
+
+func (m *promMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *promMetrics) observeRequest(r *http.Request, status int, bytes int64, duration time.Duration) {
+	if m == nil {
+		return
+	}
+
+	// Label by route pattern rather than path to bound cardinality
+	route := "unmatched"
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		route = rctx.RoutePattern()
+	}
+
+	class := strconv.Itoa(status/100) + "xx"
+
+	m.requests.WithLabelValues(route, r.Method, class).Inc()
+	m.requestDuration.WithLabelValues(route, r.Method).Observe(duration.Seconds())
+	m.responses.WithLabelValues(route, strconv.Itoa(status)).Inc()
+	m.responseSize.WithLabelValues(route).Observe(float64(bytes))
+}
+
+func (m *promMetrics) inFlight(delta float64) {
+	if m == nil {
+		return
+	}
+
+	m.requestsInFlight.Add(delta)
+}
+
+func (m *promMetrics) rateLimitRejected() {
+	if m == nil {
+		return
+	}
+
+	m.rateLimited.Inc()
+}
+
+func (m *promMetrics) rateLimitFailed() {
+	if m == nil {
+		return
+	}
+
+	m.rateLimitErrors.Inc()
+}
+
+func (m *promMetrics) ipRuleRejected() {
+	if m == nil {
+		return
+	}
+
+	m.ipBlocked.Inc()
+}
+
+func (m *promMetrics) ipBanned() {
+	if m == nil {
+		return
+	}
+
+	m.ipBans.Inc()
+}
+
+func (m *promMetrics) mailSent(outcome string) {
+	if m == nil {
+		return
+	}
+
+	m.mailSends.WithLabelValues(outcome).Inc()
+}
+
+func (m *promMetrics) backgroundStarted() {
+	if m == nil {
+		return
+	}
+
+	m.backgroundActive.Inc()
+}
+
+func (m *promMetrics) backgroundFinished(result string) {
+	if m == nil {
+		return
+	}
+
+	m.backgroundActive.Dec()
+	m.backgroundTasks.WithLabelValues(result).Inc()
+}
+
+// Collects pgxpool statistics on every scrape.
+type poolCollector struct {
+	pool *pgxpool.Pool
+
+	acquireCount            *prometheus.Desc
+	acquireDuration         *prometheus.Desc
+	acquiredConns           *prometheus.Desc
+	canceledAcquireCount    *prometheus.Desc
+	constructingConns       *prometheus.Desc
+	emptyAcquireCount       *prometheus.Desc
+	idleConns               *prometheus.Desc
+	maxConns                *prometheus.Desc
+	maxIdleDestroyCount     *prometheus.Desc
+	maxLifetimeDestroyCount *prometheus.Desc
+	newConnsCount           *prometheus.Desc
+	totalConns              *prometheus.Desc
+}
+
+func newPoolCollector(pool *pgxpool.Pool) *poolCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "pgxpool", name), help, nil, nil)
+	}
+
+	return &poolCollector{
+		pool:                    pool,
+		acquireCount:            desc("acquire_total", "Successful connection acquires from the pool."),
+		acquireDuration:         desc("acquire_duration_seconds_total", "Time spent acquiring connections from the pool."),
+		acquiredConns:           desc("acquired_conns", "Connections currently acquired from the pool."),
+		canceledAcquireCount:    desc("canceled_acquire_total", "Acquires canceled by a context."),
+		constructingConns:       desc("constructing_conns", "Connections currently being constructed."),
+		emptyAcquireCount:       desc("empty_acquire_total", "Acquires that waited for a connection because the pool was empty."),
+		idleConns:               desc("idle_conns", "Idle connections in the pool."),
+		maxConns:                desc("max_conns", "Maximum size of the pool."),
+		maxIdleDestroyCount:     desc("max_idle_destroy_total", "Connections destroyed for exceeding the max idle time."),
+		maxLifetimeDestroyCount: desc("max_lifetime_destroy_total", "Connections destroyed for exceeding the max lifetime."),
+		newConnsCount:           desc("new_conns_total", "New connections opened."),
+		totalConns:              desc("total_conns", "Total connections in the pool."),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	st := c.pool.Stat()
+
+	counter := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, v)
+	}
+	gauge := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v)
+	}
+
+	counter(c.acquireCount, float64(st.AcquireCount()))
+	counter(c.acquireDuration, st.AcquireDuration().Seconds())
+	gauge(c.acquiredConns, float64(st.AcquiredConns()))
+	counter(c.canceledAcquireCount, float64(st.CanceledAcquireCount()))
+	gauge(c.constructingConns, float64(st.ConstructingConns()))
+	counter(c.emptyAcquireCount, float64(st.EmptyAcquireCount()))
+	gauge(c.idleConns, float64(st.IdleConns()))
+	gauge(c.maxConns, float64(st.MaxConns()))
+	counter(c.maxIdleDestroyCount, float64(st.MaxIdleDestroyCount()))
+	counter(c.maxLifetimeDestroyCount, float64(st.MaxLifetimeDestroyCount()))
+	counter(c.newConnsCount, float64(st.NewConnsCount()))
+	gauge(c.totalConns, float64(st.TotalConns()))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	app := &application{prom: newMetrics(nil)}
+
+	r := chi.NewRouter()
+	r.Use(app.metrics)
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("not found"))
+	})
+
+	for _, path := range []string{"/users/1", "/users/2"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	app.prom.rateLimitRejected()
+	app.prom.mailSent("sent")
+
+	rr := httptest.NewRecorder()
+	app.prom.handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	b, _ := io.ReadAll(rr.Body)
+	body := string(b)
+
+	for _, want := range []string{
+		`api_http_requests_total{method="GET",route="/users/{id}",status="4xx"} 2`,
+		`api_http_request_duration_seconds_count{method="GET",route="/users/{id}"} 2`,
+		`api_total_responses_sent_by_status{route="/users/{id}",status="404"} 2`,
+		`api_http_response_size_bytes_sum{route="/users/{id}"} 18`,
+		`api_http_requests_in_flight 0`,
+		`api_rate_limit_rejections_total 1`,
+		`api_mail_sends_total{outcome="sent"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
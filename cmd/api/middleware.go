@@ -1,33 +1,98 @@
 package main
 
 import (
+	"context"
 	"errors"
-	"expvar"
 	"fmt"
+	"log/slog"
 	"net/http"
-	"strings"
-	"sync"
+	"strconv"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid/v5"
 	"github.com/micahco/api/internal/data"
-	"github.com/tomasen/realip"
-	"golang.org/x/time/rate"
+	"github.com/micahco/api/internal/ratelimit"
 )
 
-func (app *application) metrics(next http.Handler) http.Handler {
-	totalRequestsReceived := expvar.NewInt("total_requests_received")
-	totalResponsesSent := expvar.NewInt("total_responses_sent")
-	totalProcessingTimeMicroseconds := expvar.NewInt("total_processing_time_μs")
+const requestIDHeader = "X-Request-ID"
+
+// Use the caller's X-Request-ID when it looks safe to log, otherwise
+// generate one, and echo it in the response.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.Must(uuid.NewV4()).String()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+
+		next.ServeHTTP(w, contextSetRequestID(r, id))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
 
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// Fields set by inner handlers for the access log
+type accessLogEntry struct {
+	userID string
+}
+
+// Log one line per request once it has been served.
+func (app *application) accessLog(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
-		totalRequestsReceived.Add(1)
-		next.ServeHTTP(w, r)
-		totalResponsesSent.Add(1)
+		entry := &accessLogEntry{}
+		r = r.WithContext(context.WithValue(r.Context(), accessLogContextKey, entry))
 
-		duration := time.Since(start).Microseconds()
-		totalProcessingTimeMicroseconds.Add(duration)
+		rw := newResponseWriter(w)
+		next.ServeHTTP(rw, r)
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", chi.RouteContext(r.Context()).RoutePattern()),
+			slog.Int("status", rw.status),
+			slog.Duration("duration", time.Since(start)),
+			slog.Int64("bytes", rw.bytes),
+		}
+		if entry.userID != "" {
+			attrs = append(attrs, slog.String("user_id", entry.userID))
+		}
+
+		app.logger.LogAttrs(r.Context(), slog.LevelInfo, "request", attrs...)
+	})
+}
+
+func (app *application) metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		app.prom.inFlight(1)
+		defer app.prom.inFlight(-1)
+
+		rw := newResponseWriter(w)
+		next.ServeHTTP(rw, r)
+
+		// The route pattern is only complete once routing has finished
+		app.prom.observeRequest(r, rw.status, rw.bytes, time.Since(start))
 	})
 }
 
@@ -37,7 +102,7 @@ func (app *application) recovery(next http.Handler) http.Handler {
 			if err := recover(); err != nil {
 				w.Header().Set("Connection", "close")
 
-				app.serverErrorResponse(w, "middleware: recoverer", fmt.Errorf("%s", err))
+				app.serverErrorResponse(w, r, "middleware: recoverer", fmt.Errorf("%s", err))
 			}
 		}()
 
@@ -45,115 +110,160 @@ func (app *application) recovery(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) rateLimit(next http.Handler) http.Handler {
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
+// Named rate limit for a group of routes. A zero limit uses the
+// configured limiter rps and burst.
+type rateLimitPolicy struct {
+	name  string
+	limit ratelimit.Limit
+}
 
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-		maxAge  = 3 * time.Minute
-	)
-
-	// Background routine to remove old entries every minute
-	go func() {
-		for {
-			time.Sleep(time.Minute)
-
-			// Lock the mutex to prevent any rate limiter checks from happening while
-			// the cleanup is taking place.
-			mu.Lock()
-
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > maxAge {
-					delete(clients, ip)
-				}
+// Limit requests to the routes using policy, counting authenticated
+// requests by user and anonymous ones by IP address. Must run after
+// authenticate.
+func (app *application) rateLimit(policy rateLimitPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := "ip:" + contextGetClientIP(r)
+			if user := app.contextGetUser(r); !user.IsAnonymous() {
+				key = "user:" + user.ID.String()
 			}
 
-			mu.Unlock()
-		}
-	}()
+			if app.allowRequest(w, r, policy, key) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if app.config.limiter.enabled {
-			ip := realip.FromRequest(r)
-
-			// Lock the mutex to prevent this code from being executed concurrently.
-			mu.Lock()
-
-			// Check to see if the IP address already exists in the map. If it doesn't, then
-			// initialize a new rate limiter and add the IP address and limiter to the map.
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{
-					limiter: rate.NewLimiter(
-						rate.Limit(app.config.limiter.rps),
-						app.config.limiter.burst,
-					),
-				}
+// Limit requests by client address alone. Runs before authenticate,
+// so requests with invalid credentials are limited too.
+func (app *application) rateLimitIP(policy rateLimitPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if app.allowRequest(w, r, policy, "ip:"+contextGetClientIP(r)) {
+				next.ServeHTTP(w, r)
 			}
+		})
+	}
+}
 
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.errorResponse(w, http.StatusTooManyRequests, RateLimitExceededMessage)
+// Count the request against key's budget under policy and set the
+// rate limit headers. Responds with 429 and reports false when the
+// budget is exhausted.
+func (app *application) allowRequest(w http.ResponseWriter, r *http.Request, policy rateLimitPolicy, key string) bool {
+	// Limits may change when configuration is reloaded
+	limiter := app.config().limiter
 
-				return
-			}
+	if !limiter.enabled {
+		return true
+	}
+
+	limit := policy.limit
+	if limit == (ratelimit.Limit{}) {
+		limit = ratelimit.Limit{Rate: limiter.rps, Burst: limiter.burst}
+	}
+
+	// Fail open, an unreachable store shouldn't take the API down with it
+	res, err := app.limiter.Allow(r.Context(), policy.name+":"+key, limit)
+	if err != nil {
+		app.prom.rateLimitFailed()
+		app.logger.ErrorContext(r.Context(), "unable to check rate limit, allowing request",
+			slog.String("policy", policy.name), slog.Any("err", err))
+
+		return true
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+
+	if !res.Allowed {
+		// Whole seconds, rounded up so clients don't retry early
+		retry := (res.RetryAfter + time.Second - 1) / time.Second
+		w.Header().Set("Retry-After", strconv.Itoa(max(int(retry), 1)))
 
-			mu.Unlock()
+		app.prom.rateLimitRejected()
+		app.errorResponse(w, r, http.StatusTooManyRequests, RateLimitExceededMessage)
+
+		err := app.countRejection(r)
+		if err != nil {
+			app.logger.ErrorContext(r.Context(), "unable to count rate limit rejection", slog.Any("err", err))
 		}
 
-		next.ServeHTTP(w, r)
-	})
+		return false
+	}
+
+	return true
 }
 
+// Identify the user by bearer token, or by session cookie when
+// enabled. State-changing requests authenticated by cookie must also
+// carry the session's CSRF token.
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Add the "Vary: Authorization" header to the response. This indicates to any
 		// caches that the response may vary based on the value of the Authorization
 		// header in the request.
 		w.Header().Add("Vary", "Authorization")
+		if app.config().session.cookie {
+			w.Header().Add("Vary", "Cookie")
+		}
 
-		authorizationHeader := r.Header.Get("Authorization")
-		if authorizationHeader == "" {
-			r = app.contextSetUser(r, data.AnonymousUser)
-			next.ServeHTTP(w, r)
+		token, fromCookie, err := app.authenticationToken(r)
+		if err != nil {
+			app.invalidAuthenticationTokenFailure(w, r)
 			return
 		}
 
-		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-			app.invalidAuthenticationTokenResponse(w)
+		if token == "" {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		token := headerParts[1]
-
-		user, err := app.models.User.GetForAuthenticationToken(token)
+		user, err := app.models.User.GetForAuthenticationToken(r.Context(), token)
 		if err != nil {
 			switch {
+			case (errors.Is(err, data.ErrRecordNotFound) || errors.Is(err, data.ErrExpiredToken)) && fromCookie:
+				// Forget a stale session rather than failing requests
+				// which don't need one
+				app.clearSessionCookies(w)
+				r = app.contextSetUser(r, data.AnonymousUser)
+				next.ServeHTTP(w, r)
 			case errors.Is(err, data.ErrRecordNotFound),
 				errors.Is(err, data.ErrExpiredToken):
-				app.invalidAuthenticationTokenResponse(w)
+				app.invalidAuthenticationTokenFailure(w, r)
 			default:
-				app.serverErrorResponse(w, "middleware: authenticate: GetForAuthenticationToken", err)
+				app.serverErrorResponse(w, r, "middleware: authenticate: GetForAuthenticationToken", err)
 			}
 			return
 		}
 
+		if fromCookie && !isSafeMethod(r.Method) && !validCSRFToken(token, r.Header.Get(csrfHeader)) {
+			app.errorResponse(w, r, http.StatusForbidden, InvalidCSRFTokenMessage)
+			return
+		}
+
 		r = app.contextSetUser(r, user)
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// Reject an invalid token, charging the attempt to the client address
+// under authRateLimit so tokens can't be guessed faster than
+// credentials.
+func (app *application) invalidAuthenticationTokenFailure(w http.ResponseWriter, r *http.Request) {
+	if app.allowRequest(w, r, authRateLimit, "ip:"+contextGetClientIP(r)) {
+		app.invalidAuthenticationTokenResponse(w, r)
+	}
+}
+
 func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
 		if user.IsAnonymous() {
-			app.errorResponse(w, http.StatusUnauthorized, AuthenticationRequiredMessage)
+			app.errorResponse(w, r, http.StatusUnauthorized, AuthenticationRequiredMessage)
 
 			return
 		}
@@ -162,6 +272,20 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+func (app *application) requireAdmin(next http.Handler) http.Handler {
+	return app.requireAuthentication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if !user.Admin {
+			app.errorResponse(w, r, http.StatusForbidden, AdminRequiredMessage)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}))
+}
+
 func secureHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Security-Policy",
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/micahco/api/internal/data"
+	"github.com/micahco/api/internal/ratelimit"
+)
+
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("connection refused")
+}
+
+func (failingLimiter) Purge(ctx context.Context) error {
+	return nil
+}
+
+func TestRateLimitFailOpen(t *testing.T) {
+	app := &application{
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		limiter: failingLimiter{},
+		prom:    newMetrics(nil),
+	}
+
+	var cfg config
+	cfg.limiter.enabled = true
+	cfg.limiter.rps = 0.1
+	cfg.limiter.burst = 1
+	app.setConfig(cfg)
+
+	h := app.rateLimit(rateLimitPolicy{name: "test"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i := range 3 {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r = app.contextSetUser(r, data.AnonymousUser)
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("request %d: got %d", i+1, rr.Code)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	app.prom.handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if want := "api_rate_limit_errors_total 3"; !strings.Contains(rr.Body.String(), want) {
+		t.Errorf("metrics missing %q", want)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/micahco/api/internal/migrate"
+	"github.com/micahco/api/migrations"
+)
+
+const migrateUsage = `Usage: api migrate [flags] <command>
+
+Commands:
+  up          Apply all pending migrations
+  down [N]    Roll back the latest N applied migrations (default 1)
+  status      List migrations and whether each has been applied
+  version     Print the applied migration version
+
+Flags are the same as the server, only -config and -db-dsn are used.
+`
+
+// Run the migrate subcommand, returning the exit code.
+func migrateCmd(args []string, stdout, stderr io.Writer) int {
+	var cfg config
+	var opts options
+
+	fs := newFlagSet("api migrate", flag.ContinueOnError, &cfg, &opts)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, migrateUsage)
+	}
+
+	err := loadConfig(fs, args, os.LookupEnv)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 2
+	}
+
+	if cfg.db.dsn == "" {
+		fmt.Fprintln(stderr, "db.dsn: must be provided")
+		return 2
+	}
+
+	pool, err := openPool(cfg.db.dsn)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer pool.Close()
+
+	migrator, err := migrate.New(pool, migrations.Files)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	ctx := context.Background()
+
+	switch cmd := fs.Arg(0); cmd {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Fprintf(stdout, "%d/u %s\n", m.Version, m.Name)
+		}
+		if errors.Is(err, migrate.ErrNoChange) {
+			fmt.Fprintln(stdout, "no change")
+			return 0
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+
+	case "down":
+		n := 1
+		if fs.NArg() > 1 {
+			n, err = strconv.Atoi(fs.Arg(1))
+			if err != nil || n < 1 {
+				fmt.Fprintf(stderr, "invalid number of migrations %q\n", fs.Arg(1))
+				return 2
+			}
+		}
+
+		reverted, err := migrator.Down(ctx, n)
+		for _, m := range reverted {
+			fmt.Fprintf(stdout, "%d/d %s\n", m.Version, m.Name)
+		}
+		if errors.Is(err, migrate.ErrNoChange) {
+			fmt.Fprintln(stdout, "no change")
+			return 0
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+
+		tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, s := range status {
+			fmt.Fprintf(tw, "%d\t%s\t%t\n", s.Version, s.Name, s.Applied)
+		}
+		tw.Flush()
+
+	case "version":
+		version, dirty, err := migrator.Version(ctx)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+
+		if dirty {
+			fmt.Fprintf(stdout, "%d (dirty)\n", version)
+		} else {
+			fmt.Fprintln(stdout, version)
+		}
+
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n", cmd)
+		fs.Usage()
+		return 2
+	}
+
+	return 0
+}
+
+// Apply pending migrations before the server starts. Replicas
+// starting together wait on the advisory lock held by the first.
+func migrateOnStart(migrator *migrate.Migrator, timeout time.Duration) ([]migrate.Migration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	applied, err := migrator.Up(ctx)
+	if errors.Is(err, migrate.ErrNoChange) {
+		return nil, nil
+	}
+
+	return applied, err
+}
+
+func checkSchema(migrator *migrate.Migrator) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return migrator.Check(ctx)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Config keys which take effect without restarting the server
+var reloadableSections = []string{"limiter", "ban", "cors", "smtp"}
+var reloadableKeys = map[string]bool{
+	"log_level":     true,
+	"max_body_size": true,
+}
+
+// Keys in reloadable sections which still require a restart
+var restartKeys = map[string]bool{
+	"limiter.store": true,
+}
+
+func isReloadable(key string) bool {
+	if restartKeys[key] {
+		return false
+	}
+
+	for _, section := range reloadableSections {
+		if strings.HasPrefix(key, section+".") {
+			return true
+		}
+	}
+
+	return reloadableKeys[key]
+}
+
+type configChange struct {
+	Key     string `json:"key"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+	Restart bool   `json:"restart_required"`
+}
+
+// Keys which differ between two configurations, with secret values
+// redacted.
+func diffConfig(old, new config) []configChange {
+	oldValues := old.values()
+	newValues := new.values()
+
+	var changes []configChange
+	for key, o := range oldValues {
+		n := newValues[key]
+		if o == n {
+			continue
+		}
+
+		c := configChange{
+			Key:     key,
+			Old:     o,
+			New:     n,
+			Restart: !isReloadable(key),
+		}
+		if isSecretKey(key) {
+			c.Old, c.New = "[REDACTED]", "[REDACTED]"
+		}
+
+		changes = append(changes, c)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+
+	return changes
+}
+
+// Re-read configuration from the same sources used at startup and
+// swap in the settings which are safe to change while running: rate
+// limits and bans, CORS, mail transport, body size and log level. Anything else is logged as
+// requiring a restart.
+func (app *application) reload() ([]configChange, error) {
+	if app.loadConfig == nil {
+		return nil, errors.New("configuration reload is not supported")
+	}
+
+	app.reloadMu.Lock()
+	defer app.reloadMu.Unlock()
+
+	loaded, err := app.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	current := app.config()
+	next := current
+
+	if loaded.smtp != current.smtp {
+		err := app.mailer.SetSMTP(
+			loaded.smtp.host,
+			loaded.smtp.port,
+			loaded.smtp.username,
+			loaded.smtp.password,
+			mailSender(loaded),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	next.smtp = loaded.smtp
+	next.limiter = loaded.limiter
+	next.ban = loaded.ban
+	next.cors = loaded.cors
+	next.logLevel = loaded.logLevel
+	next.maxBodySize = loaded.maxBodySize
+
+	if app.logLevel != nil {
+		app.logLevel.Set(next.slogLevel())
+	}
+
+	app.setConfig(next)
+
+	changes := diffConfig(current, loaded)
+	for _, c := range changes {
+		attrs := []any{
+			slog.String("key", c.Key),
+			slog.String("old", c.Old),
+			slog.String("new", c.New),
+		}
+
+		if c.Restart {
+			app.logger.Warn("configuration change requires restart", attrs...)
+		} else {
+			app.logger.Info("configuration changed", attrs...)
+		}
+	}
+
+	return changes, nil
+}
+
+func (app *application) adminConfigReloadPost(w http.ResponseWriter, r *http.Request) error {
+	changes, err := app.reload()
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), "unable to reload configuration", slog.Any("err", err))
+		return app.writeError(w, http.StatusUnprocessableEntity, err.Error())
+	}
+
+	if changes == nil {
+		changes = []configChange{}
+	}
+
+	return app.writeJSON(w, http.StatusOK, envelope{"changes": changes}, nil)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	var cfg config
+	err := loadConfig(newTestFlagSet(&cfg), []string{"-db-dsn", "postgres://localhost/api"}, func(string) (string, bool) { return "", false })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := cfg
+	next.limiter.rps = 10
+	next.limiter.burst = 20
+	next.logLevel = "warn"
+	next.port = 9000
+	next.db.dsn = "postgres://localhost/other"
+
+	app := &application{
+		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		logLevel: new(slog.LevelVar),
+		loadConfig: func() (config, error) {
+			return next, nil
+		},
+	}
+	app.setConfig(cfg)
+
+	changes, err := app.reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := app.config()
+	if got.limiter.rps != 10 || got.limiter.burst != 20 {
+		t.Errorf("limiter not reloaded: %+v", got.limiter)
+	}
+	if app.logLevel.Level() != slog.LevelWarn {
+		t.Errorf("log level: got %v; want %v", app.logLevel.Level(), slog.LevelWarn)
+	}
+	if got.port != cfg.port || got.db.dsn != cfg.db.dsn {
+		t.Errorf("restart-only settings were swapped: port %d, dsn %q", got.port, got.db.dsn)
+	}
+
+	want := map[string]bool{
+		"db.dsn":        true,
+		"limiter.burst": false,
+		"limiter.rps":   false,
+		"log_level":     false,
+		"port":          true,
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("got %d changes; want %d: %+v", len(changes), len(want), changes)
+	}
+	for _, c := range changes {
+		restart, ok := want[c.Key]
+		if !ok {
+			t.Errorf("unexpected change %q", c.Key)
+			continue
+		}
+		if c.Restart != restart {
+			t.Errorf("%s: restart_required = %v; want %v", c.Key, c.Restart, restart)
+		}
+		if c.Key == "db.dsn" && (c.Old != "[REDACTED]" || c.New != "[REDACTED]") {
+			t.Errorf("db.dsn change not redacted: %+v", c)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// Wraps http.ResponseWriter to record the status code and number of
+// bytes written, while still exposing http.Flusher and http.Hijacker
+// when the underlying writer supports them.
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	return &responseWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	// Informational responses may be followed by the final status
+	if !rw.wroteHeader && status >= 200 {
+		rw.status = status
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+func (rw *responseWriter) Flush() {
+	rw.wroteHeader = true
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+
+	// The status is unknown once the connection is taken over
+	rw.status = http.StatusSwitchingProtocols
+	rw.wroteHeader = true
+
+	return h.Hijack()
+}
+
+// Allow http.ResponseController to reach the underlying writer.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
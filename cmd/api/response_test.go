@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, nil
+}
+
+func TestResponseWriter(t *testing.T) {
+	t.Run("status and bytes", func(t *testing.T) {
+		rw := newResponseWriter(httptest.NewRecorder())
+		rw.WriteHeader(http.StatusCreated)
+		rw.WriteHeader(http.StatusInternalServerError)
+		rw.Write([]byte("hello"))
+		rw.Write([]byte(" world"))
+
+		if rw.status != http.StatusCreated {
+			t.Errorf("status = %d, want %d", rw.status, http.StatusCreated)
+		}
+		if rw.bytes != 11 {
+			t.Errorf("bytes = %d, want 11", rw.bytes)
+		}
+	})
+
+	t.Run("implicit ok", func(t *testing.T) {
+		rw := newResponseWriter(httptest.NewRecorder())
+		rw.Write([]byte("ok"))
+		rw.WriteHeader(http.StatusTeapot)
+
+		if rw.status != http.StatusOK {
+			t.Errorf("status = %d, want %d", rw.status, http.StatusOK)
+		}
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		rw := newResponseWriter(rec)
+
+		err := http.NewResponseController(rw).Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !rec.Flushed {
+			t.Error("underlying writer not flushed")
+		}
+	})
+
+	t.Run("hijack", func(t *testing.T) {
+		rw := newResponseWriter(hijackRecorder{httptest.NewRecorder()})
+		if _, _, err := rw.Hijack(); err != nil {
+			t.Fatal(err)
+		}
+
+		rw = newResponseWriter(httptest.NewRecorder())
+		if _, _, err := rw.Hijack(); err == nil {
+			t.Error("expected error hijacking unsupported writer")
+		}
+	})
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"io/fs"
+	"log/slog"
 	"net/http"
 	"os"
 	"path"
@@ -10,31 +11,58 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/micahco/api/internal/ratelimit"
 	"github.com/micahco/api/ui"
 )
 
+// Rate limit policies, each client has a separate budget per policy
+var (
+	// Configured by limiter.rps and limiter.burst
+	defaultRateLimit = rateLimitPolicy{name: "default"}
+	// Credentials, tokens and mail are expensive and open to abuse
+	authRateLimit = rateLimitPolicy{name: "auth", limit: ratelimit.Limit{Rate: 0.2, Burst: 5}}
+	// Authenticated clients polling their own account
+	userRateLimit = rateLimitPolicy{name: "user", limit: ratelimit.Limit{Rate: 10, Burst: 20}}
+	// Every request from an address, checked before authentication
+	clientRateLimit = rateLimitPolicy{name: "client", limit: ratelimit.Limit{Rate: 20, Burst: 40}}
+)
+
 // App router
 func (app *application) routes() http.Handler {
 	r := chi.NewRouter()
+	r.Use(app.clientIP)
+	r.Use(app.requestID)
+	r.Use(app.accessLog)
+	r.Use(app.cors)
+
+	// Probes skip rate limiting and authentication
+	r.Route("/api/v1/health", func(r chi.Router) {
+		r.Use(app.recovery)
+
+		r.Get("/live", app.handle(app.healthLive))
+		r.Get("/ready", app.handle(app.healthReady))
+	})
 
 	r.Route("/api", func(r chi.Router) {
 		// Middleware
 		r.Use(middleware.StripSlashes)
+		r.Use(app.trace)
 		r.Use(app.metrics)
 		r.Use(app.recovery)
-		r.Use(app.rateLimit)
+		r.Use(app.ipFilter)
+		r.Use(app.rateLimitIP(clientRateLimit))
 		r.Use(app.authenticate)
 		r.Use(secureHeaders)
 
-		// Metrics
-		r.Mount("/debug", middleware.Profiler())
-
 		// API
 		r.Route("/v1", func(r chi.Router) {
-			r.Get("/healthcheck", app.handle(app.healthcheck))
+			r.With(app.rateLimit(defaultRateLimit)).Get("/healthcheck", app.handle(app.healthcheck))
 
 			r.Route("/tokens", func(r chi.Router) {
+				r.Use(app.rateLimit(authRateLimit))
+
 				r.Post("/authentication", app.handle(app.tokensAuthenticationPost))
+				r.With(app.requireAuthentication).Delete("/authentication", app.handle(app.tokensAuthenticationDelete))
 
 				r.Route("/verification", func(r chi.Router) {
 					r.Post("/registration", app.handle(app.tokensVerificaitonRegistrationPost))
@@ -49,16 +77,41 @@ func (app *application) routes() http.Handler {
 			})
 
 			r.Route("/users", func(r chi.Router) {
-				r.Post("/", app.handle(app.usersPost))
-				r.Put("/password", app.handle(app.usersPasswordPut))
+				r.Group(func(r chi.Router) {
+					r.Use(app.rateLimit(authRateLimit))
+
+					r.Post("/", app.handle(app.usersPost))
+					r.Put("/password", app.handle(app.usersPasswordPut))
+				})
 
 				r.Route("/me", func(r chi.Router) {
 					r.Use(app.requireAuthentication)
+					r.Use(app.rateLimit(userRateLimit))
 
 					r.Get("/", app.handle(app.usersMeGet))
 					r.Put("/", app.handle(app.usersMePut))
 				})
 			})
+
+			r.With(app.rateLimit(defaultRateLimit)).Post("/mail/events", app.handle(app.mailEventsPost))
+
+			r.Route("/admin", func(r chi.Router) {
+				r.Use(app.requireAdmin)
+				r.Use(app.rateLimit(defaultRateLimit))
+
+				r.Post("/config/reload", app.handle(app.adminConfigReloadPost))
+
+				r.Route("/mail/suppressions", func(r chi.Router) {
+					r.Get("/", app.handle(app.adminMailSuppressionsGet))
+					r.Delete("/{email}", app.handle(app.adminMailSuppressionsDelete))
+				})
+
+				r.Route("/ip-rules", func(r chi.Router) {
+					r.Get("/", app.handle(app.adminIPRulesGet))
+					r.Post("/", app.handle(app.adminIPRulesPost))
+					r.Delete("/{id}", app.handle(app.adminIPRulesDelete))
+				})
+			})
 		})
 	})
 
@@ -67,6 +120,16 @@ func (app *application) routes() http.Handler {
 	return r
 }
 
+// Profiler and expvar, only served on the debug listener
+func (app *application) debugRoutes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(app.recovery)
+
+	r.Mount("/debug", middleware.Profiler())
+
+	return r
+}
+
 func (app *application) spaHandler() http.HandlerFunc {
 	fsys, err := fs.Sub(ui.Files, "frontend")
 	if err != nil {
@@ -86,16 +149,30 @@ func (app *application) spaHandler() http.HandlerFunc {
 
 func (app *application) healthcheck(w http.ResponseWriter, r *http.Request) error {
 	env := "production"
-	if app.config.dev {
+	if app.config().dev {
 		env = "development"
 	}
 
+	systemInfo := map[string]any{
+		"environment": env,
+		"version":     version,
+	}
+
+	// Best effort, the healthcheck reports the server is up even when
+	// the database isn't
+	if app.migrator != nil {
+		schemaVersion, _, err := app.migrator.Version(r.Context())
+		if err != nil {
+			app.logger.WarnContext(r.Context(), "unable to read schema version", slog.Any("err", err))
+			systemInfo["schema_version"] = nil
+		} else {
+			systemInfo["schema_version"] = schemaVersion
+		}
+	}
+
 	data := envelope{
-		"status": "available",
-		"system_info": map[string]string{
-			"environment": env,
-			"version":     version,
-		},
+		"status":      "available",
+		"system_info": systemInfo,
 	}
 
 	return app.writeJSON(w, http.StatusOK, data, nil)
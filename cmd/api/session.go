@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/micahco/api/internal/data"
+)
+
+// Session cookies hold an authentication token out of reach of
+// JavaScript. The CSRF cookie is readable so the SPA can echo it in
+// the CSRF header, which a cross-site request can't do.
+const (
+	sessionCookieName = "session"
+	csrfCookieName    = "csrf_token"
+	csrfHeader        = "X-CSRF-Token"
+)
+
+var errInvalidAuthorizationHeader = errors.New("invalid authorization header")
+
+// Authentication token from the Authorization header, or else from the
+// session cookie when cookie sessions are enabled. An empty token means
+// the request is anonymous.
+func (app *application) authenticationToken(r *http.Request) (token string, fromCookie bool, err error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		headerParts := strings.Split(header, " ")
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			return "", false, errInvalidAuthorizationHeader
+		}
+
+		return headerParts[1], false, nil
+	}
+
+	if !app.config().session.cookie {
+		return "", false, nil
+	}
+
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return "", false, nil
+	}
+
+	return cookie.Value, true, nil
+}
+
+// CSRF token for a session, derived from the session token so it
+// needs no storage and can't be forged without the session.
+func csrfToken(session string) string {
+	mac := hmac.New(sha256.New, []byte(session))
+	mac.Write([]byte(csrfCookieName))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func validCSRFToken(session, token string) bool {
+	return hmac.Equal([]byte(token), []byte(csrfToken(session)))
+}
+
+// Methods which must not change state, so don't need a CSRF token.
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+// Set the session and CSRF cookies for t, expiring with it.
+func (app *application) setSessionCookies(w http.ResponseWriter, t *data.Token) {
+	sameSite := app.config().sessionSameSite()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    t.Plaintext,
+		Path:     "/",
+		Expires:  t.Expiry,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: sameSite,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    csrfToken(t.Plaintext),
+		Path:     "/",
+		Expires:  t.Expiry,
+		Secure:   true,
+		SameSite: sameSite,
+	})
+}
+
+func (app *application) clearSessionCookies(w http.ResponseWriter) {
+	sameSite := app.config().sessionSameSite()
+
+	for _, name := range []string{sessionCookieName, csrfCookieName} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Path:     "/",
+			Expires:  time.Unix(0, 0),
+			MaxAge:   -1,
+			HttpOnly: name == sessionCookieName,
+			Secure:   true,
+			SameSite: sameSite,
+		})
+	}
+}
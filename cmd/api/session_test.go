@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSessionCookie(t *testing.T) {
+	app, _ := newTestApplication(t)
+	h := app.routes()
+
+	email := uniqueEmail("session", "example.com")
+	cleanupUsers(t, app, email)
+
+	user, err := app.models.User.New(context.Background(), email, "secret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(method, path string, body any, header http.Header, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		t.Helper()
+
+		js, _ := json.Marshal(body)
+		r := httptest.NewRequest(method, path, bytes.NewReader(js))
+		r.Header.Set("Content-Type", "application/json")
+		for k, v := range header {
+			r.Header.Set(k, v[0])
+		}
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+
+		return rr
+	}
+
+	credentials := map[string]any{"email": email, "password": "secret-password", "cookie": true}
+
+	rr := serve(http.MethodPost, "/api/v1/tokens/authentication", credentials, nil)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("cookie sessions disabled: got %d", rr.Code)
+	}
+
+	cfg := app.config()
+	cfg.session.cookie = true
+	cfg.session.sameSite = "strict"
+	app.setConfig(cfg)
+
+	rr = serve(http.MethodPost, "/api/v1/tokens/authentication", credentials, nil)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("sign in: got %d %s", rr.Code, rr.Body)
+	}
+
+	var env struct {
+		Token   any `json:"authentication_token"`
+		Session struct {
+			CSRFToken string `json:"csrf_token"`
+		} `json:"session"`
+	}
+	err = json.NewDecoder(rr.Body).Decode(&env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Token != nil {
+		t.Errorf("token in response body: %v", env.Token)
+	}
+
+	var session, csrf *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		switch c.Name {
+		case sessionCookieName:
+			session = c
+		case csrfCookieName:
+			csrf = c
+		}
+	}
+	if session == nil || !session.HttpOnly || !session.Secure || session.SameSite != http.SameSiteStrictMode {
+		t.Fatalf("session cookie: %+v", session)
+	}
+	if csrf == nil || csrf.HttpOnly || csrf.Value != env.Session.CSRFToken {
+		t.Fatalf("csrf cookie: %+v; want readable with value %q", csrf, env.Session.CSRFToken)
+	}
+
+	withCSRF := http.Header{csrfHeader: {csrf.Value}}
+
+	tests := []struct {
+		name   string
+		method string
+		header http.Header
+		want   int
+	}{
+		{"safe method", http.MethodGet, nil, http.StatusOK},
+		{"missing csrf token", http.MethodPut, nil, http.StatusForbidden},
+		{"wrong csrf token", http.MethodPut, http.Header{csrfHeader: {csrfToken("other")}}, http.StatusForbidden},
+		{"csrf token", http.MethodPut, withCSRF, http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serve(tt.method, "/api/v1/users/me", map[string]any{}, tt.header, session)
+			if rr.Code != tt.want {
+				t.Errorf("got %d %s; want %d", rr.Code, rr.Body, tt.want)
+			}
+		})
+	}
+
+	// Bearer tokens don't need a CSRF token
+	token, err := app.models.AuthenticationToken.New(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = serve(http.MethodPut, "/api/v1/users/me", map[string]any{}, http.Header{"Authorization": {"Bearer " + token.Plaintext}})
+	if rr.Code != http.StatusCreated {
+		t.Errorf("bearer: got %d %s", rr.Code, rr.Body)
+	}
+
+	rr = serve(http.MethodDelete, "/api/v1/tokens/authentication", nil, withCSRF, session)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("sign out: got %d %s", rr.Code, rr.Body)
+	}
+	for _, c := range rr.Result().Cookies() {
+		if c.MaxAge >= 0 {
+			t.Errorf("cookie not cleared: %+v", c)
+		}
+	}
+
+	// The signed out session is forgotten, not an error
+	rr = serve(http.MethodGet, "/api/v1/healthcheck", nil, nil, session)
+	if rr.Code != http.StatusOK || len(rr.Result().Cookies()) != 2 {
+		t.Errorf("stale session: got %d with cookies %v", rr.Code, rr.Result().Cookies())
+	}
+
+	rr = serve(http.MethodGet, "/api/v1/users/me", nil, nil, session)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("signed out session: got %d", rr.Code)
+	}
+}
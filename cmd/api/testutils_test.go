@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/quotedprintable"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/micahco/api/internal/data"
+	"github.com/micahco/api/internal/mailer"
+	"github.com/micahco/api/internal/ratelimit"
+	"github.com/micahco/api/ui"
+)
+
+// Mailbox captures outgoing mail instead of delivering it.
+type testMailbox struct {
+	mu       sync.Mutex
+	messages map[string][]string
+}
+
+func (mb *testMailbox) Send(from string, to []string, msg io.WriterTo) error {
+	buf := new(bytes.Buffer)
+	_, err := msg.WriteTo(buf)
+	if err != nil {
+		return err
+	}
+
+	m, err := mail.ReadMessage(buf)
+	if err != nil {
+		return err
+	}
+
+	body, err := io.ReadAll(quotedprintable.NewReader(m.Body))
+	if err != nil {
+		return err
+	}
+
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	for _, rcpt := range to {
+		mb.messages[rcpt] = append(mb.messages[rcpt], string(body))
+	}
+
+	return nil
+}
+
+// Return the body of the latest message sent to recipient.
+func (mb *testMailbox) last(t *testing.T, recipient string) string {
+	t.Helper()
+
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	msgs := mb.messages[recipient]
+	if len(msgs) == 0 {
+		t.Fatalf("no mail sent to %s", recipient)
+	}
+
+	return msgs[len(msgs)-1]
+}
+
+var mailLinkRX = regexp.MustCompile(`https?://\S+`)
+
+// Return the token from the link in the latest message sent to
+// recipient.
+func (mb *testMailbox) token(t *testing.T, recipient string) string {
+	t.Helper()
+
+	body := mb.last(t, recipient)
+
+	link := mailLinkRX.FindString(body)
+	if link == "" {
+		t.Fatalf("no link in mail to %s:\n%s", recipient, body)
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := u.Query().Get("token")
+	if token == "" {
+		t.Fatalf("no token in link %s", link)
+	}
+
+	return token
+}
+
+// Number of messages sent to recipient.
+func (mb *testMailbox) count(recipient string) int {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	return len(mb.messages[recipient])
+}
+
+// Create application backed by in-memory models, or by the database
+// at API_TEST_DB_DSN if set, which must have all migrations applied.
+// Outgoing mail is captured by the returned mailbox.
+func newTestApplication(t *testing.T) (*application, *testMailbox) {
+	t.Helper()
+
+	mb := &testMailbox{messages: map[string][]string{}}
+	sender := &mail.Address{Name: "Do Not Reply", Address: "no-reply@example.com"}
+
+	m, err := mailer.NewWithTransport(sender, ui.Files, "mail/*.tmpl", mb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg config
+	cfg.baseURL = "http://spa.example.com"
+	cfg.maxBodySize = 1 << 20
+	cfg.trustedProxyHeader = "X-Forwarded-For"
+
+	app := &application{
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		mailer:  m,
+		models:  data.NewMemory(),
+		limiter: ratelimit.NewMemory(time.Minute),
+	}
+	app.setConfig(cfg)
+
+	if dsn := os.Getenv("API_TEST_DB_DSN"); dsn != "" {
+		pool, err := openPool(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(pool.Close)
+
+		app.pool = pool
+		app.models = data.New(pool)
+	}
+
+	// Loaded at startup by main
+	err = app.refreshIPRules(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return app, mb
+}
+
+type testServer struct {
+	*httptest.Server
+	app *application
+}
+
+func newTestServer(t *testing.T, app *application) *testServer {
+	t.Helper()
+
+	ts := httptest.NewServer(app.routes())
+	t.Cleanup(ts.Close)
+
+	return &testServer{ts, app}
+}
+
+// Send a JSON request to the server, optionally authenticated with
+// bearer token, and decode the JSON response body. Waits for any
+// background tasks (e.g. mail) started by the request to complete.
+func (ts *testServer) request(t *testing.T, method, path, token string, body any) (int, map[string]any) {
+	t.Helper()
+
+	var rb io.Reader
+	if body != nil {
+		js, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rb = bytes.NewReader(js)
+	}
+
+	req, err := http.NewRequest(method, ts.URL+path, rb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	ts.app.wg.Wait()
+
+	var env map[string]any
+	err = json.NewDecoder(res.Body).Decode(&env)
+	if err != nil {
+		t.Fatalf("%s %s: decoding response: %v", method, path, err)
+	}
+
+	return res.StatusCode, env
+}
+
+// Delete users with emails, and any tokens belonging to them, once
+// the test completes. Only needed when testing against a database.
+func cleanupUsers(t *testing.T, app *application, emails ...string) {
+	if app.pool == nil {
+		return
+	}
+
+	t.Cleanup(func() {
+		ctx := context.Background()
+
+		_, err := app.pool.Exec(ctx, `DELETE FROM verification_token_ WHERE email_ = ANY($1);`, emails)
+		if err != nil {
+			t.Error(err)
+		}
+
+		_, err = app.pool.Exec(ctx, `DELETE FROM user_ WHERE email_ = ANY($1);`, emails)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// Create an administrator and return their authentication token.
+func newTestAdmin(t *testing.T, app *application) string {
+	t.Helper()
+
+	ctx := context.Background()
+
+	email := uniqueEmail("admin", "example.com")
+	cleanupUsers(t, app, email)
+
+	user := &data.User{Email: email, Admin: true}
+
+	err := user.SetPasswordHash(ctx, "secret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.User.Insert(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only granted directly in the database
+	if app.pool != nil {
+		_, err := app.pool.Exec(ctx, `UPDATE user_ SET admin_ = TRUE WHERE id_ = $1;`, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token, err := app.models.AuthenticationToken.New(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token.Plaintext
+}
+
+// Generate an email address that won't collide with other test runs.
+func uniqueEmail(name, domain string) string {
+	return fmt.Sprintf("%s-%d@%s", name, time.Now().UnixNano(), domain)
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
 
 	validation "github.com/go-ozzo/ozzo-validation"
@@ -20,7 +22,7 @@ func (app *application) tokensVerificaitonRegistrationPost(w http.ResponseWriter
 		Email string `json:"email"`
 	}
 
-	err := app.readJSON(r, &input)
+	err := app.readJSON(w, r, &input)
 	if err != nil {
 		return err
 	}
@@ -37,7 +39,7 @@ func (app *application) tokensVerificaitonRegistrationPost(w http.ResponseWriter
 	msg := envelope{"message": verificationMsg}
 
 	// Check if user with email already exists
-	exists, err := app.models.User.ExistsWithEmail(input.Email)
+	exists, err := app.models.User.ExistsWithEmail(r.Context(), input.Email)
 	if err != nil {
 		return err
 	}
@@ -48,7 +50,7 @@ func (app *application) tokensVerificaitonRegistrationPost(w http.ResponseWriter
 	}
 
 	// Check if a verification token has already been created recently
-	exists, err = app.models.VerificationToken.Exists(data.ScopeRegistration, input.Email, nil)
+	exists, err = app.models.VerificationToken.Exists(r.Context(), data.ScopeRegistration, input.Email, nil)
 	if err != nil {
 		return err
 	}
@@ -58,20 +60,20 @@ func (app *application) tokensVerificaitonRegistrationPost(w http.ResponseWriter
 		return app.writeJSON(w, http.StatusOK, msg, nil)
 	}
 
-	t, err := app.models.VerificationToken.New(data.ScopeRegistration, input.Email, nil)
+	t, err := app.models.VerificationToken.New(r.Context(), data.ScopeRegistration, input.Email, nil)
 	if err != nil {
 		return err
 	}
 
 	// Mail the plaintext token to the user's email address.
-	app.background(func() error {
+	app.background(r.Context(), func(ctx context.Context) error {
 		data := map[string]any{
-			"base":  app.config.baseURL,
+			"base":  app.config().baseURL,
 			"email": input.Email,
 			"token": t.Plaintext,
 		}
 
-		return app.mailer.Send(input.Email, "registration.tmpl", data)
+		return app.mailer.Send(ctx, input.Email, "registration.tmpl", data)
 	})
 
 	return app.writeJSON(w, http.StatusOK, msg, nil)
@@ -82,7 +84,7 @@ func (app *application) tokensVerificaitonEmailChangePost(w http.ResponseWriter,
 		Email string `json:"email"`
 	}
 
-	err := app.readJSON(r, &input)
+	err := app.readJSON(w, r, &input)
 	if err != nil {
 		return err
 	}
@@ -99,7 +101,7 @@ func (app *application) tokensVerificaitonEmailChangePost(w http.ResponseWriter,
 	msg := envelope{"message": verificationMsg}
 
 	// Check if user with email already exists
-	exists, err := app.models.User.ExistsWithEmail(input.Email)
+	exists, err := app.models.User.ExistsWithEmail(r.Context(), input.Email)
 	if err != nil {
 		return err
 	}
@@ -113,7 +115,7 @@ func (app *application) tokensVerificaitonEmailChangePost(w http.ResponseWriter,
 	user := app.contextGetUser(r)
 
 	// Check if a verification token has already been created recently
-	exists, err = app.models.VerificationToken.Exists(data.ScopeEmailChange, input.Email, &user.ID)
+	exists, err = app.models.VerificationToken.Exists(r.Context(), data.ScopeEmailChange, input.Email, &user.ID)
 	if err != nil {
 		return err
 	}
@@ -124,18 +126,20 @@ func (app *application) tokensVerificaitonEmailChangePost(w http.ResponseWriter,
 	}
 
 	// Create verification token for user with new email address
-	t, err := app.models.VerificationToken.New(data.ScopeEmailChange, input.Email, &user.ID)
+	t, err := app.models.VerificationToken.New(r.Context(), data.ScopeEmailChange, input.Email, &user.ID)
 	if err != nil {
 		return err
 	}
 
 	// Mail the plaintext token to the new email address
-	app.background(func() error {
+	app.background(r.Context(), func(ctx context.Context) error {
 		data := map[string]any{
+			"base":  app.config().baseURL,
+			"email": input.Email,
 			"token": t.Plaintext,
 		}
 
-		return app.mailer.Send(input.Email, "email-change.tmpl", data)
+		return app.mailer.Send(ctx, input.Email, "email-change.tmpl", data)
 	})
 
 	return app.writeJSON(w, http.StatusOK, msg, nil)
@@ -146,7 +150,7 @@ func (app *application) tokensVerificaitonPasswordResetPost(w http.ResponseWrite
 		Email string `json:"email"`
 	}
 
-	err := app.readJSON(r, &input)
+	err := app.readJSON(w, r, &input)
 	if err != nil {
 		return err
 	}
@@ -163,7 +167,7 @@ func (app *application) tokensVerificaitonPasswordResetPost(w http.ResponseWrite
 	msg := envelope{"message": verificationMsg}
 
 	// Check if user with email exists
-	exists, err := app.models.User.ExistsWithEmail(input.Email)
+	exists, err := app.models.User.ExistsWithEmail(r.Context(), input.Email)
 	if err != nil {
 		return err
 	}
@@ -173,7 +177,7 @@ func (app *application) tokensVerificaitonPasswordResetPost(w http.ResponseWrite
 	}
 
 	// Check if a verification token has already been created recently
-	exists, err = app.models.VerificationToken.Exists(data.ScopePasswordReset, input.Email, nil)
+	exists, err = app.models.VerificationToken.Exists(r.Context(), data.ScopePasswordReset, input.Email, nil)
 	if err != nil {
 		return err
 	}
@@ -182,20 +186,20 @@ func (app *application) tokensVerificaitonPasswordResetPost(w http.ResponseWrite
 		return app.writeJSON(w, http.StatusOK, msg, nil)
 	}
 
-	t, err := app.models.VerificationToken.New(data.ScopePasswordReset, input.Email, nil)
+	t, err := app.models.VerificationToken.New(r.Context(), data.ScopePasswordReset, input.Email, nil)
 	if err != nil {
 		return err
 	}
 
 	// Mail the plaintext token to the user's email address
-	app.background(func() error {
+	app.background(r.Context(), func(ctx context.Context) error {
 		data := map[string]any{
-			"base":  app.config.baseURL,
+			"base":  app.config().baseURL,
 			"token": t.Plaintext,
 			"email": input.Email,
 		}
 
-		return app.mailer.Send(input.Email, "password-reset.tmpl", data)
+		return app.mailer.Send(ctx, input.Email, "password-reset.tmpl", data)
 	})
 
 	return app.writeJSON(w, http.StatusOK, msg, nil)
@@ -205,9 +209,10 @@ func (app *application) tokensAuthenticationPost(w http.ResponseWriter, r *http.
 	var input struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		Cookie   bool   `json:"cookie"`
 	}
 
-	err := app.readJSON(r, &input)
+	err := app.readJSON(w, r, &input)
 	if err != nil {
 		return err
 	}
@@ -220,7 +225,11 @@ func (app *application) tokensAuthenticationPost(w http.ResponseWriter, r *http.
 		return err
 	}
 
-	user, err := app.models.User.GetForCredentials(input.Email, input.Password)
+	if input.Cookie && !app.config().session.cookie {
+		return validation.Errors{"cookie": errors.New("session cookies are disabled")}
+	}
+
+	user, err := app.models.User.GetForCredentials(r.Context(), input.Email, input.Password)
 	if err != nil {
 		if err == data.ErrInvalidCredentials {
 			return app.writeError(w, http.StatusUnauthorized, InvalidCredentailsMessage)
@@ -229,10 +238,41 @@ func (app *application) tokensAuthenticationPost(w http.ResponseWriter, r *http.
 		return err
 	}
 
-	t, err := app.models.AuthenticationToken.New(user.ID)
+	t, err := app.models.AuthenticationToken.New(r.Context(), user.ID)
 	if err != nil {
 		return err
 	}
 
+	// The token stays in the HttpOnly cookie, out of the response
+	if input.Cookie {
+		app.setSessionCookies(w, t)
+
+		session := envelope{"expiry": t.Expiry, "csrf_token": csrfToken(t.Plaintext)}
+
+		return app.writeJSON(w, http.StatusCreated, envelope{"session": session}, nil)
+	}
+
 	return app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": t}, nil)
 }
+
+// Sign out by deleting the token which authenticated the request,
+// along with the session cookies if it came from one.
+func (app *application) tokensAuthenticationDelete(w http.ResponseWriter, r *http.Request) error {
+	token, fromCookie, err := app.authenticationToken(r)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.AuthenticationToken.Delete(r.Context(), token)
+	if err != nil {
+		return err
+	}
+
+	if fromCookie {
+		app.clearSessionCookies(w)
+	}
+
+	msg := envelope{"message": "Signed out."}
+
+	return app.writeJSON(w, http.StatusOK, msg, nil)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/micahco/api/cmd/api"
+
+// Install the global tracer provider and W3C trace context propagator.
+// Spans are exported to the OTLP collector at cfg.trace.endpoint, or
+// dropped when it is empty. The returned function flushes pending spans.
+func setupTracing(cfg config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if cfg.trace.endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(cfg.trace.endpoint),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName("api"),
+		semconv.ServiceVersion(version),
+	)
+
+	tp := newTracerProvider(sdktrace.NewBatchSpanProcessor(exporter), res, cfg.trace.sampleRatio)
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
+
+func newTracerProvider(sp sdktrace.SpanProcessor, res *resource.Resource, ratio float64) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(sp),
+		sdktrace.WithResource(res),
+		// Follow the caller's sampling decision when there is one
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+}
+
+// Start a server span for each request, continuing the trace from the
+// traceparent header if present.
+func (app *application) trace(next http.Handler) http.Handler {
+	tracer := otel.Tracer(tracerName)
+	propagator := otel.GetTextMapPropagator()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.ClientAddress(contextGetClientIP(r)),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			),
+		)
+		defer span.End()
+
+		rw := newResponseWriter(w)
+		next.ServeHTTP(rw, r.WithContext(ctx))
+
+		// Name the span by route pattern once routing has finished
+		if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+			route := rctx.RoutePattern()
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rw.status))
+		if rw.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rw.status))
+		}
+	})
+}
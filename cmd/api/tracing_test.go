@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestTraceMiddleware(t *testing.T) {
+	_, err := setupTracing(config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := newTracerProvider(sdktrace.NewSimpleSpanProcessor(exporter), resource.Empty(), 1)
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+	})
+
+	app := &application{}
+
+	r := chi.NewRouter()
+	r.Use(app.trace)
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		// Handlers see the server span in their context
+		if !trace.SpanFromContext(r.Context()).SpanContext().IsValid() {
+			t.Error("no span in request context")
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+
+	span := spans[0]
+	if span.Name != "GET /users/{id}" {
+		t.Errorf("name = %q", span.Name)
+	}
+	if got := span.SpanContext.TraceID().String(); got != traceID {
+		t.Errorf("trace id = %s, want %s", got, traceID)
+	}
+	if span.SpanKind != trace.SpanKindServer {
+		t.Errorf("kind = %s", span.SpanKind)
+	}
+	if span.Status.Code != codes.Error {
+		t.Errorf("status = %s, want error", span.Status.Code)
+	}
+
+	attrs := attribute.NewSet(span.Attributes...)
+	if v, _ := attrs.Value("http.route"); v.AsString() != "/users/{id}" {
+		t.Errorf("http.route = %q", v.AsString())
+	}
+	if v, _ := attrs.Value("http.response.status_code"); v.AsInt64() != http.StatusInternalServerError {
+		t.Errorf("http.response.status_code = %d", v.AsInt64())
+	}
+}
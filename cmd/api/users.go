@@ -17,7 +17,7 @@ func (app *application) usersPost(w http.ResponseWriter, r *http.Request) error
 		Token    string `json:"token"`
 	}
 
-	err := app.readJSON(r, &input)
+	err := app.readJSON(w, r, &input)
 	if err != nil {
 		return err
 	}
@@ -31,7 +31,23 @@ func (app *application) usersPost(w http.ResponseWriter, r *http.Request) error
 		return err
 	}
 
-	err = app.models.VerificationToken.Verify(input.Token, data.ScopeRegistration, input.Email, nil)
+	// Consume the token and create the user together, so a failure
+	// can't leave the token purged without an account.
+	var user *data.User
+	err = app.models.WithTx(r.Context(), func(m data.Models) error {
+		err := m.VerificationToken.Verify(r.Context(), input.Token, data.ScopeRegistration, input.Email, nil)
+		if err != nil {
+			return err
+		}
+
+		err = m.VerificationToken.PurgeWithEmail(r.Context(), input.Email)
+		if err != nil {
+			return err
+		}
+
+		user, err = m.User.New(r.Context(), input.Email, input.Password)
+		return err
+	})
 	if err != nil {
 		switch err {
 		case data.ErrRecordNotFound:
@@ -43,16 +59,6 @@ func (app *application) usersPost(w http.ResponseWriter, r *http.Request) error
 		}
 	}
 
-	err = app.models.VerificationToken.PurgeWithEmail(input.Email)
-	if err != nil {
-		return err
-	}
-
-	user, err := app.models.User.New(input.Email, input.Password)
-	if err != nil {
-		return err
-	}
-
 	return app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
 }
 
@@ -63,7 +69,7 @@ func (app *application) usersPasswordPut(w http.ResponseWriter, r *http.Request)
 		Token    string `json:"token"`
 	}
 
-	err := app.readJSON(r, &input)
+	err := app.readJSON(w, r, &input)
 	if err != nil {
 		return err
 	}
@@ -77,7 +83,7 @@ func (app *application) usersPasswordPut(w http.ResponseWriter, r *http.Request)
 		return err
 	}
 
-	user, err := app.models.User.GetForVerificationToken(data.ScopePasswordReset, input.Token)
+	user, err := app.models.User.GetForVerificationToken(r.Context(), data.ScopePasswordReset, input.Token)
 	if err != nil {
 		switch err {
 		case data.ErrRecordNotFound:
@@ -93,25 +99,25 @@ func (app *application) usersPasswordPut(w http.ResponseWriter, r *http.Request)
 		return app.writeError(w, http.StatusUnauthorized, nil)
 	}
 
-	err = user.SetPasswordHash(input.Password)
+	err = user.SetPasswordHash(r.Context(), input.Password)
 	if err != nil {
 		return err
 	}
 
-	err = app.models.User.Update(user)
-	if err != nil {
-		switch {
-		default:
+	// Update the password and revoke outstanding tokens atomically
+	err = app.models.WithTx(r.Context(), func(m data.Models) error {
+		err := m.User.Update(r.Context(), user)
+		if err != nil {
 			return err
 		}
-	}
 
-	err = app.models.VerificationToken.PurgeWithEmail(user.Email)
-	if err != nil {
-		return err
-	}
+		err = m.VerificationToken.PurgeWithEmail(r.Context(), user.Email)
+		if err != nil {
+			return err
+		}
 
-	err = app.models.AuthenticationToken.Purge(user.ID)
+		return m.AuthenticationToken.Purge(r.Context(), user.ID)
+	})
 	if err != nil {
 		return err
 	}
@@ -133,7 +139,7 @@ func (app *application) usersMePut(w http.ResponseWriter, r *http.Request) error
 		Token *string `json:"token"`
 	}
 
-	err := app.readJSON(r, &input)
+	err := app.readJSON(w, r, &input)
 	if err != nil {
 		return err
 	}
@@ -148,30 +154,32 @@ func (app *application) usersMePut(w http.ResponseWriter, r *http.Request) error
 
 	user := app.contextGetUser(r)
 
-	if input.Email != nil && input.Token != nil {
-		err = app.models.VerificationToken.Verify(*input.Token, data.ScopeEmailChange, *input.Email, &user.ID)
-		if err != nil {
-			switch err {
-			case data.ErrRecordNotFound:
-				return app.writeError(w, http.StatusUnauthorized, nil)
-			case data.ErrExpiredToken:
-				return app.writeError(w, http.StatusUnauthorized, "Expired token. Please request another email change token.")
-			default:
+	err = app.models.WithTx(r.Context(), func(m data.Models) error {
+		if input.Email != nil && input.Token != nil {
+			err := m.VerificationToken.Verify(r.Context(), *input.Token, data.ScopeEmailChange, *input.Email, &user.ID)
+			if err != nil {
 				return err
 			}
-		}
 
-		err = app.models.VerificationToken.PurgeWithUserID(user.ID)
-		if err != nil {
-			return err
-		}
+			err = m.VerificationToken.PurgeWithUserID(r.Context(), user.ID)
+			if err != nil {
+				return err
+			}
 
-		user.Email = *input.Email
-	}
+			user.Email = *input.Email
+		}
 
-	err = app.models.User.Update(user)
+		return m.User.Update(r.Context(), user)
+	})
 	if err != nil {
-		return err
+		switch err {
+		case data.ErrRecordNotFound:
+			return app.writeError(w, http.StatusUnauthorized, nil)
+		case data.ErrExpiredToken:
+			return app.writeError(w, http.StatusUnauthorized, "Expired token. Please request another email change token.")
+		default:
+			return err
+		}
 	}
 
 	return app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
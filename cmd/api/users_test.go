@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+)
+
+var emailChangeLinkRX = regexp.MustCompile(`\S+/email-change\?\S+`)
+
+func TestEmailChangeFlow(t *testing.T) {
+	app, mb := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	oldEmail := uniqueEmail("email-change-old", "example.com")
+	newEmail := uniqueEmail("email-change-new", "example.org")
+	password := "secret-password"
+	cleanupUsers(t, app, oldEmail, newEmail)
+
+	_, err := app.models.User.New(context.Background(), oldEmail, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Login with the current email
+	status, env := ts.request(t, http.MethodPost, "/api/v1/tokens/authentication", "", map[string]string{
+		"email":    oldEmail,
+		"password": password,
+	})
+	if status != http.StatusCreated {
+		t.Fatalf("login: got status %d; want %d: %v", status, http.StatusCreated, env)
+	}
+	authToken := env["authentication_token"].(map[string]any)["token"].(string)
+
+	// Request a verification token for the new email
+	status, env = ts.request(t, http.MethodPost, "/api/v1/tokens/verification/email-change", authToken, map[string]string{
+		"email": newEmail,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("email change request: got status %d; want %d: %v", status, http.StatusOK, env)
+	}
+	if env["message"] != verificationMsg {
+		t.Errorf("email change request: got message %q; want %q", env["message"], verificationMsg)
+	}
+
+	// The new address receives a confirmation link to the SPA
+	body := mb.last(t, newEmail)
+	link := emailChangeLinkRX.FindString(body)
+	if link == "" {
+		t.Fatalf("no confirmation link in mail:\n%s", body)
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Scheme + "://" + u.Host; got != app.config().baseURL {
+		t.Errorf("link base: got %q; want %q", got, app.config().baseURL)
+	}
+	if u.Path != "/email-change" {
+		t.Errorf("link path: got %q; want %q", u.Path, "/email-change")
+	}
+	if got := u.Query().Get("email"); got != newEmail {
+		t.Errorf("link email: got %q; want %q", got, newEmail)
+	}
+	verificationToken := u.Query().Get("token")
+	if verificationToken == "" {
+		t.Fatal("link is missing token")
+	}
+
+	// Confirm the change the same way the SPA does
+	confirm := map[string]string{
+		"email": newEmail,
+		"token": verificationToken,
+	}
+	status, env = ts.request(t, http.MethodPut, "/api/v1/users/me", authToken, confirm)
+	if status != http.StatusCreated {
+		t.Fatalf("confirm: got status %d; want %d: %v", status, http.StatusCreated, env)
+	}
+	if got := env["user"].(map[string]any)["email"]; got != newEmail {
+		t.Errorf("confirm: got email %q; want %q", got, newEmail)
+	}
+
+	status, env = ts.request(t, http.MethodGet, "/api/v1/users/me", authToken, nil)
+	if status != http.StatusOK {
+		t.Fatalf("get user: got status %d; want %d: %v", status, http.StatusOK, env)
+	}
+	if got := env["user"].(map[string]any)["email"]; got != newEmail {
+		t.Errorf("get user: got email %q; want %q", got, newEmail)
+	}
+
+	// The token is single use
+	status, _ = ts.request(t, http.MethodPut, "/api/v1/users/me", authToken, confirm)
+	if status != http.StatusUnauthorized {
+		t.Errorf("reused token: got status %d; want %d", status, http.StatusUnauthorized)
+	}
+
+	// Only the new email can be used to login
+	status, _ = ts.request(t, http.MethodPost, "/api/v1/tokens/authentication", "", map[string]string{
+		"email":    oldEmail,
+		"password": password,
+	})
+	if status != http.StatusUnauthorized {
+		t.Errorf("login with old email: got status %d; want %d", status, http.StatusUnauthorized)
+	}
+
+	status, env = ts.request(t, http.MethodPost, "/api/v1/tokens/authentication", "", map[string]string{
+		"email":    newEmail,
+		"password": password,
+	})
+	if status != http.StatusCreated {
+		t.Errorf("login with new email: got status %d; want %d: %v", status, http.StatusCreated, env)
+	}
+}
@@ -0,0 +1,68 @@
+import { Show, createSignal } from "solid-js";
+import { A } from "@solidjs/router";
+import { api, HTTPError, APIError } from "../utils/api";
+
+interface Props {
+	email: string;
+	token: string;
+}
+
+export default function EmailChangeConfirmForm(props: Props) {
+	const [isSubmitting, setIsSubmitting] = createSignal(false);
+	const [isConfirmed, setIsConfirmed] = createSignal(false);
+	const [errMsg, setErrMsg] = createSignal<string | null>(null);
+
+	const handleSubmit = async (e: Event) => {
+		e.preventDefault();
+		setIsSubmitting(true);
+		setErrMsg(null);
+		try {
+			await api.put("users/me", {
+				json: {
+					email: props.email,
+					token: props.token,
+				},
+			});
+
+			setIsConfirmed(true);
+		} catch (err) {
+			if (err instanceof HTTPError) {
+				const data = await err.response.json<APIError>();
+				if (typeof data.error === "string") {
+					setErrMsg(data.error);
+				} else if (data.error.email) {
+					setErrMsg(data.error.email);
+				}
+			}
+		} finally {
+			setIsSubmitting(false);
+		}
+	};
+
+	return (
+		<Show
+			when={!isConfirmed()}
+			fallback={
+				<p>
+					Your email was changed to {props.email}.{" "}
+					<A href="/">Return to dashboard</A>
+				</p>
+			}
+		>
+			<form onSubmit={handleSubmit}>
+				<p>Change your account email to {props.email}?</p>
+
+				<button
+					type="submit"
+					disabled={isSubmitting() || !props.email || !props.token}
+				>
+					Confirm Email
+				</button>
+
+				<Show when={errMsg()}>
+					<p class="err">{errMsg()}</p>
+				</Show>
+			</form>
+		</Show>
+	);
+}
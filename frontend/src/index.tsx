@@ -10,6 +10,7 @@ import Login from "./pages/Login";
 import Signup from "./pages/Signup";
 import PasswordReset from "./pages/PasswordReset";
 import PasswordUpdate from "./pages/PasswordUpdate";
+import EmailChange from "./pages/EmailChange";
 import NotFound from "./pages/NotFound";
 
 render(
@@ -22,6 +23,7 @@ render(
 					<Route path="/signup" component={Signup} />
 					<Route path="/password-reset" component={PasswordReset} />
 					<Route path="/password-update" component={PasswordUpdate} />
+					<Route path="/email-change" component={EmailChange} />
 					<Route path="*" component={NotFound} />
 				</Router>
 			</FlashProvider>
@@ -0,0 +1,35 @@
+import { Show } from "solid-js";
+import { A, useSearchParams } from "@solidjs/router";
+import { useAuth } from "../contexts/AuthProvider";
+import EmailChangeConfirmForm from "../components/EmailChangeConfirmForm";
+
+export default function EmailChange() {
+	const [searchParams] = useSearchParams();
+	const [isAuthenticated] = useAuth();
+
+	let token = "";
+	if (searchParams.token && typeof searchParams.token === "string") {
+		token = searchParams.token;
+	}
+	let email = "";
+	if (searchParams.email && typeof searchParams.email === "string") {
+		email = searchParams.email;
+	}
+
+	return (
+		<>
+			<h1>Confirm Email</h1>
+			<Show
+				when={isAuthenticated()}
+				fallback={
+					<p>
+						Please <A href="/login">login</A> and then follow the
+						link in the email again.
+					</p>
+				}
+			>
+				<EmailChangeConfirmForm token={token} email={email} />
+			</Show>
+		</>
+	);
+}
@@ -6,14 +6,13 @@ import (
 
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/gofrs/uuid/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // Default expiry duration
 const AuthenticationTokenTTL = time.Hour * 36
 
 type AuthenticationTokenModel struct {
-	pool *pgxpool.Pool
+	db querier
 }
 
 type AuthenticationToken struct {
@@ -28,7 +27,7 @@ func (at AuthenticationToken) Validate() error {
 		validation.Field(&at.UserID, validation.Required))
 }
 
-func (m AuthenticationTokenModel) New(userID uuid.UUID) (*Token, error) {
+func (m AuthenticationTokenModel) New(ctx context.Context, userID uuid.UUID) (*Token, error) {
 	t, err := generateToken(AuthenticationTokenTTL)
 	if err != nil {
 		return nil, err
@@ -36,7 +35,7 @@ func (m AuthenticationTokenModel) New(userID uuid.UUID) (*Token, error) {
 
 	at := &AuthenticationToken{userID, t}
 
-	err = m.Insert(at)
+	err = m.Insert(ctx, at)
 	if err != nil {
 		return nil, err
 	}
@@ -44,7 +43,7 @@ func (m AuthenticationTokenModel) New(userID uuid.UUID) (*Token, error) {
 	return t, err
 }
 
-func (m AuthenticationTokenModel) Insert(t *AuthenticationToken) error {
+func (m AuthenticationTokenModel) Insert(ctx context.Context, t *AuthenticationToken) error {
 	err := t.Validate()
 	if err != nil {
 		return err
@@ -56,21 +55,34 @@ func (m AuthenticationTokenModel) Insert(t *AuthenticationToken) error {
 
 	args := []any{t.Hash, t.Expiry, t.UserID}
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	_, err = m.pool.Exec(ctx, sql, args...)
+	_, err = m.db.Exec(ctx, sql, args...)
 	return err
 }
 
-func (m AuthenticationTokenModel) Purge(userID uuid.UUID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+func (m AuthenticationTokenModel) Purge(ctx context.Context, userID uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
 	sql := `
 		DELETE FROM authentication_token_
 		WHERE user_id_ = $1;`
 
-	_, err := m.pool.Exec(ctx, sql, userID)
+	_, err := m.db.Exec(ctx, sql, userID)
+	return err
+}
+
+// Delete a single token, e.g. when signing out.
+func (m AuthenticationTokenModel) Delete(ctx context.Context, token string) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	sql := `
+		DELETE FROM authentication_token_
+		WHERE hash_ = $1;`
+
+	_, err := m.db.Exec(ctx, sql, generateHash(token))
 	return err
 }
@@ -0,0 +1,216 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	IPRuleAllow = "allow"
+	IPRuleDeny  = "deny"
+)
+
+// Allow or deny requests from a network, until ExpiresAt if set. The
+// most specific matching network wins, so an allow rule can exempt
+// part of a denied network. There is at most one rule per network.
+type IPRule struct {
+	ID        uuid.UUID    `json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	Network   netip.Prefix `json:"network"`
+	Action    string       `json:"action"`
+	Reason    string       `json:"reason"`
+	ExpiresAt *time.Time   `json:"expires_at"`
+}
+
+func (rule IPRule) Validate() error {
+	return validation.ValidateStruct(&rule,
+		validation.Field(&rule.Network, validation.By(func(any) error {
+			if !rule.Network.IsValid() {
+				return errors.New("must be an IP address or CIDR")
+			}
+			return nil
+		})),
+		validation.Field(&rule.Action, validation.Required, validation.In(IPRuleAllow, IPRuleDeny)),
+		validation.Field(&rule.ExpiresAt, validation.Min(time.Now()).Error("must be in the future")),
+	)
+}
+
+// Parse an IP address or CIDR, as a network containing only that
+// address in the first case.
+func ParseNetwork(s string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return prefix.Masked(), nil
+}
+
+// Best matching rule for addr, taking precedence as described by
+// IPRule. Expired rules are ignored. This is Match without a query,
+// e.g. against rules previously loaded with GetAll.
+func BestIPRule(rules []IPRule, addr netip.Addr, now time.Time) (IPRule, bool) {
+	var best IPRule
+	var found bool
+
+	addr = addr.Unmap()
+
+	for _, rule := range rules {
+		if !rule.Network.Contains(addr) || (rule.ExpiresAt != nil && !rule.ExpiresAt.After(now)) {
+			continue
+		}
+
+		if !found || rule.Network.Bits() > best.Network.Bits() {
+			best, found = rule, true
+		}
+	}
+
+	return best, found
+}
+
+type IPRuleModel struct {
+	db querier
+}
+
+// Insert rule for its network. An existing rule for the same network
+// is replaced.
+func (m IPRuleModel) Insert(ctx context.Context, rule *IPRule) error {
+	rule.Network = rule.Network.Masked()
+
+	err := rule.Validate()
+	if err != nil {
+		return err
+	}
+
+	sql := `
+		INSERT INTO ip_rule_ (network_, action_, reason_, expires_at_)
+		VALUES($1, $2, $3, $4)
+		ON CONFLICT (network_) DO UPDATE
+		SET action_ = EXCLUDED.action_, reason_ = EXCLUDED.reason_,
+			expires_at_ = EXCLUDED.expires_at_
+		RETURNING id_, created_at_;`
+
+	args := []any{rule.Network, rule.Action, rule.Reason, rule.ExpiresAt}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	return m.db.QueryRow(ctx, sql, args...).Scan(&rule.ID, &rule.CreatedAt)
+}
+
+// Rule which applies to addr, or ErrRecordNotFound if none does.
+func (m IPRuleModel) Match(ctx context.Context, addr netip.Addr) (*IPRule, error) {
+	sql := `
+		SELECT id_, created_at_, network_, action_, reason_, expires_at_
+		FROM ip_rule_
+		WHERE network_ >>= $1::inet
+		AND (expires_at_ IS NULL OR expires_at_ > NOW())
+		ORDER BY masklen(network_) DESC
+		LIMIT 1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	var rule IPRule
+	err := m.db.QueryRow(ctx, sql, addr.Unmap()).Scan(
+		&rule.ID,
+		&rule.CreatedAt,
+		&rule.Network,
+		&rule.Action,
+		&rule.Reason,
+		&rule.ExpiresAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &rule, nil
+}
+
+// Rules which have not expired, newest first.
+func (m IPRuleModel) GetAll(ctx context.Context) ([]*IPRule, error) {
+	sql := `
+		SELECT id_, created_at_, network_, action_, reason_, expires_at_
+		FROM ip_rule_
+		WHERE expires_at_ IS NULL OR expires_at_ > NOW()
+		ORDER BY created_at_ DESC;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	rows, err := m.db.Query(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []*IPRule{}
+	for rows.Next() {
+		var rule IPRule
+
+		err := rows.Scan(
+			&rule.ID,
+			&rule.CreatedAt,
+			&rule.Network,
+			&rule.Action,
+			&rule.Reason,
+			&rule.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, &rule)
+	}
+
+	return rules, rows.Err()
+}
+
+func (m IPRuleModel) Delete(ctx context.Context, id uuid.UUID) error {
+	sql := `
+		DELETE FROM ip_rule_
+		WHERE id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	result, err := m.db.Exec(ctx, sql, id)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Delete expired rules.
+func (m IPRuleModel) Purge(ctx context.Context) error {
+	sql := `
+		DELETE FROM ip_rule_
+		WHERE expires_at_ < NOW();`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.Exec(ctx, sql)
+	return err
+}
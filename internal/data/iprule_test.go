@@ -0,0 +1,118 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestIPRuleModel(t *testing.T) {
+	pool := newTestPool(t)
+	m := IPRuleModel{pool}
+	ctx := context.Background()
+
+	// A network of our own, other tests share the table
+	base := netip.MustParsePrefix(fmt.Sprintf("198.18.%d.0/24", time.Now().UnixNano()%256))
+	t.Cleanup(func() {
+		pool.Exec(context.Background(), `DELETE FROM ip_rule_ WHERE network_ <<= $1;`, base)
+	})
+
+	host := func(last byte) netip.Addr {
+		a := base.Addr().As4()
+		a[3] = last
+		return netip.AddrFrom4(a)
+	}
+
+	deny := &IPRule{Network: base, Action: IPRuleDeny}
+	allow := &IPRule{Network: netip.PrefixFrom(host(128), 25), Action: IPRuleAllow}
+	ban := &IPRule{Network: netip.PrefixFrom(host(200), 32), Action: IPRuleDeny, Reason: "banned"}
+
+	for _, rule := range []*IPRule{deny, allow, ban} {
+		err := m.Insert(ctx, rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	match := func(addr netip.Addr) string {
+		t.Helper()
+
+		rule, err := m.Match(ctx, addr)
+		if errors.Is(err, ErrRecordNotFound) {
+			return ""
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return rule.Action
+	}
+
+	tests := []struct {
+		addr netip.Addr
+		want string
+	}{
+		{host(9), IPRuleDeny},
+		{host(130), IPRuleAllow},
+		{host(200), IPRuleDeny},
+		{netip.AddrFrom16(host(130).As16()), IPRuleAllow},
+		{netip.MustParseAddr("192.0.2.1"), ""},
+	}
+	for _, tt := range tests {
+		if got := match(tt.addr); got != tt.want {
+			t.Errorf("Match %s: got %q; want %q", tt.addr, got, tt.want)
+		}
+	}
+
+	// A rule for the same network replaces the existing one
+	replace := &IPRule{Network: base, Action: IPRuleAllow, Reason: "replaced"}
+	err := m.Insert(ctx, replace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replace.ID != deny.ID {
+		t.Errorf("replacing rule got id %s; want %s", replace.ID, deny.ID)
+	}
+	if got := match(host(9)); got != IPRuleAllow {
+		t.Errorf("Match after replace: got %q; want %q", got, IPRuleAllow)
+	}
+
+	// Expired rules stop matching and are purged
+	_, err = pool.Exec(ctx, `UPDATE ip_rule_ SET expires_at_ = NOW() - INTERVAL '1 minute' WHERE id_ = $1;`, ban.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := match(host(200)); got != IPRuleAllow {
+		t.Errorf("Match expired: got %q; want %q", got, IPRuleAllow)
+	}
+
+	all, err := m.GetAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[netip.Prefix]string{}
+	for _, rule := range all {
+		if base.Overlaps(rule.Network) {
+			got[rule.Network] = rule.Reason
+		}
+	}
+	want := map[netip.Prefix]string{base: "replaced", allow.Network: ""}
+	if !maps.Equal(got, want) {
+		t.Errorf("GetAll: got %v; want %v", got, want)
+	}
+
+	err = m.Purge(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Delete(ctx, ban.ID)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("Delete purged rule: got %v; want ErrRecordNotFound", err)
+	}
+}
@@ -0,0 +1,549 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// In-memory tables behind the stores returned by NewMemory.
+type memoryDB struct {
+	mu                   sync.Mutex
+	txMu                 sync.Mutex
+	users                map[uuid.UUID]User
+	verificationTokens   map[string]VerificationToken
+	authenticationTokens map[string]AuthenticationToken
+	ipRules              map[uuid.UUID]IPRule
+}
+
+// Create models backed by memory instead of PostgreSQL, for testing
+// handlers in isolation. Unique emails (compared case-insensitively
+// like citext), optimistic versioning and token expiry behave the same
+// as the PostgreSQL models. MailSuppression, MailSend and RateLimit
+// are not supported and must not be used.
+func NewMemory() Models {
+	db := &memoryDB{
+		users:                map[uuid.UUID]User{},
+		verificationTokens:   map[string]VerificationToken{},
+		authenticationTokens: map[string]AuthenticationToken{},
+		ipRules:              map[uuid.UUID]IPRule{},
+	}
+
+	return db.models(false)
+}
+
+func (db *memoryDB) models(inTx bool) Models {
+	return Models{
+		User:                memoryUserModel{db, inTx},
+		VerificationToken:   memoryVerificationTokenModel{db, inTx},
+		AuthenticationToken: memoryAuthenticationTokenModel{db, inTx},
+		IPRule:              memoryIPRuleModel{db, inTx},
+		withTx: func(ctx context.Context, fn func(Models) error) error {
+			// Transactions run one at a time, nested ones act as savepoints.
+			// Writes outside a transaction wait for it to end, so restoring
+			// the snapshot on rollback can't erase them.
+			if !inTx {
+				db.txMu.Lock()
+				defer db.txMu.Unlock()
+			}
+
+			snapshot := db.snapshot()
+
+			err := fn(db.models(true))
+			if err != nil {
+				db.restore(snapshot)
+				return err
+			}
+
+			return nil
+		},
+	}
+}
+
+func (db *memoryDB) snapshot() *memoryDB {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	s := &memoryDB{
+		users:                make(map[uuid.UUID]User, len(db.users)),
+		verificationTokens:   make(map[string]VerificationToken, len(db.verificationTokens)),
+		authenticationTokens: make(map[string]AuthenticationToken, len(db.authenticationTokens)),
+		ipRules:              make(map[uuid.UUID]IPRule, len(db.ipRules)),
+	}
+	for k, v := range db.users {
+		s.users[k] = v
+	}
+	for k, v := range db.verificationTokens {
+		s.verificationTokens[k] = v
+	}
+	for k, v := range db.authenticationTokens {
+		s.authenticationTokens[k] = v
+	}
+	for k, v := range db.ipRules {
+		s.ipRules[k] = v
+	}
+
+	return s
+}
+
+func (db *memoryDB) restore(s *memoryDB) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.users = s.users
+	db.verificationTokens = s.verificationTokens
+	db.authenticationTokens = s.authenticationTokens
+	db.ipRules = s.ipRules
+}
+
+// Lock db for a write. Outside a transaction the write also waits
+// for any running transaction to finish. Call the returned func to
+// unlock.
+func (db *memoryDB) lockWrite(inTx bool) func() {
+	if !inTx {
+		db.txMu.Lock()
+	}
+	db.mu.Lock()
+
+	return func() {
+		db.mu.Unlock()
+		if !inTx {
+			db.txMu.Unlock()
+		}
+	}
+}
+
+// Find user by email, the caller must hold db.mu.
+func (db *memoryDB) userWithEmail(email string) (User, bool) {
+	for _, u := range db.users {
+		if strings.EqualFold(u.Email, email) {
+			return u, true
+		}
+	}
+
+	return User{}, false
+}
+
+var errForeignKey = errors.New("models: referenced user does not exist")
+
+type memoryUserModel struct {
+	db   *memoryDB
+	inTx bool
+}
+
+func (m memoryUserModel) New(ctx context.Context, email, password string) (*User, error) {
+	user := &User{Email: email}
+
+	err := user.SetPasswordHash(ctx, password)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (m memoryUserModel) Insert(ctx context.Context, user *User) error {
+	err := user.Validate()
+	if err != nil {
+		return err
+	}
+
+	defer m.db.lockWrite(m.inTx)()
+
+	if _, ok := m.db.userWithEmail(user.Email); ok {
+		return ErrDuplicateEmail
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	user.ID = id
+	user.CreatedAt = time.Now()
+	user.Version = 1
+
+	m.db.users[id] = *user
+
+	return nil
+}
+
+func (m memoryUserModel) GetForCredentials(ctx context.Context, email, password string) (*User, error) {
+	m.db.mu.Lock()
+	u, ok := m.db.userWithEmail(email)
+	m.db.mu.Unlock()
+
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	match, err := comparePasswordAndHash(ctx, password, string(u.PasswordHash))
+	if err != nil {
+		return nil, err
+	}
+	if !match {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &u, nil
+}
+
+func (m memoryUserModel) GetForAuthenticationToken(ctx context.Context, token string) (*User, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	at, ok := m.db.authenticationTokens[string(generateHash(token))]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	u, ok := m.db.users[at.UserID]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	if time.Now().After(at.Expiry) {
+		return nil, ErrExpiredToken
+	}
+
+	return &u, nil
+}
+
+func (m memoryUserModel) GetForVerificationToken(ctx context.Context, scope, token string) (*User, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	vt, ok := m.db.verificationTokens[string(generateHash(token))]
+	if !ok || vt.Scope != scope {
+		return nil, ErrRecordNotFound
+	}
+
+	u, ok := m.db.userWithEmail(vt.Email)
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	if time.Now().After(vt.Expiry) {
+		return nil, ErrExpiredToken
+	}
+
+	return &u, nil
+}
+
+func (m memoryUserModel) GetIDForEmail(ctx context.Context, email string) (uuid.UUID, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	u, ok := m.db.userWithEmail(email)
+	if !ok {
+		return uuid.Nil, ErrRecordNotFound
+	}
+
+	return u.ID, nil
+}
+
+func (m memoryUserModel) ExistsWithEmail(ctx context.Context, email string) (bool, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	_, ok := m.db.userWithEmail(email)
+
+	return ok, nil
+}
+
+func (m memoryUserModel) Update(ctx context.Context, user *User) error {
+	err := user.Validate()
+	if err != nil {
+		return err
+	}
+
+	defer m.db.lockWrite(m.inTx)()
+
+	u, ok := m.db.users[user.ID]
+	if !ok || u.Version != user.Version {
+		return ErrEditConflict
+	}
+
+	if other, ok := m.db.userWithEmail(user.Email); ok && other.ID != user.ID {
+		return ErrDuplicateEmail
+	}
+
+	u.Email = user.Email
+	u.PasswordHash = user.PasswordHash
+	u.Version++
+	m.db.users[u.ID] = u
+
+	user.Version = u.Version
+
+	return nil
+}
+
+type memoryVerificationTokenModel struct {
+	db   *memoryDB
+	inTx bool
+}
+
+func (m memoryVerificationTokenModel) New(ctx context.Context, scope, email string, userID *uuid.UUID) (*Token, error) {
+	t, err := generateToken(VerificationTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	vt := &VerificationToken{
+		Scope:  scope,
+		Email:  email,
+		UserID: userID,
+		Token:  t,
+	}
+
+	err = m.Insert(ctx, vt)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, err
+}
+
+func (m memoryVerificationTokenModel) Insert(ctx context.Context, vt *VerificationToken) error {
+	err := vt.Validate()
+	if err != nil {
+		return err
+	}
+
+	defer m.db.lockWrite(m.inTx)()
+
+	if vt.UserID != nil {
+		if _, ok := m.db.users[*vt.UserID]; !ok {
+			return errForeignKey
+		}
+	}
+
+	m.db.verificationTokens[string(vt.Hash)] = *vt
+
+	return nil
+}
+
+// Reports whether vt matches scope, email and, when not nil, userID.
+func (vt VerificationToken) matches(scope, email string, userID *uuid.UUID) bool {
+	if vt.Scope != scope || !strings.EqualFold(vt.Email, email) {
+		return false
+	}
+
+	if userID != nil {
+		return vt.UserID != nil && *vt.UserID == *userID
+	}
+
+	return true
+}
+
+func (m memoryVerificationTokenModel) Exists(ctx context.Context, scope, email string, userID *uuid.UUID) (bool, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	for _, vt := range m.db.verificationTokens {
+		if vt.matches(scope, email, userID) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (m memoryVerificationTokenModel) PurgeWithEmail(ctx context.Context, email string) error {
+	defer m.db.lockWrite(m.inTx)()
+
+	for hash, vt := range m.db.verificationTokens {
+		if strings.EqualFold(vt.Email, email) {
+			delete(m.db.verificationTokens, hash)
+		}
+	}
+
+	return nil
+}
+
+func (m memoryVerificationTokenModel) PurgeWithUserID(ctx context.Context, userID uuid.UUID) error {
+	defer m.db.lockWrite(m.inTx)()
+
+	for hash, vt := range m.db.verificationTokens {
+		if vt.UserID != nil && *vt.UserID == userID {
+			delete(m.db.verificationTokens, hash)
+		}
+	}
+
+	return nil
+}
+
+func (m memoryVerificationTokenModel) Verify(ctx context.Context, token, scope, email string, userID *uuid.UUID) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	vt, ok := m.db.verificationTokens[string(generateHash(token))]
+	if !ok || !vt.matches(scope, email, userID) {
+		return ErrRecordNotFound
+	}
+
+	if time.Now().After(vt.Expiry) {
+		return ErrExpiredToken
+	}
+
+	return nil
+}
+
+type memoryAuthenticationTokenModel struct {
+	db   *memoryDB
+	inTx bool
+}
+
+func (m memoryAuthenticationTokenModel) New(ctx context.Context, userID uuid.UUID) (*Token, error) {
+	t, err := generateToken(AuthenticationTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	at := &AuthenticationToken{userID, t}
+
+	err = m.Insert(ctx, at)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, err
+}
+
+func (m memoryAuthenticationTokenModel) Insert(ctx context.Context, t *AuthenticationToken) error {
+	err := t.Validate()
+	if err != nil {
+		return err
+	}
+
+	defer m.db.lockWrite(m.inTx)()
+
+	if _, ok := m.db.users[t.UserID]; !ok {
+		return errForeignKey
+	}
+
+	m.db.authenticationTokens[string(t.Hash)] = *t
+
+	return nil
+}
+
+func (m memoryAuthenticationTokenModel) Purge(ctx context.Context, userID uuid.UUID) error {
+	defer m.db.lockWrite(m.inTx)()
+
+	for hash, at := range m.db.authenticationTokens {
+		if at.UserID == userID {
+			delete(m.db.authenticationTokens, hash)
+		}
+	}
+
+	return nil
+}
+
+func (m memoryAuthenticationTokenModel) Delete(ctx context.Context, token string) error {
+	defer m.db.lockWrite(m.inTx)()
+
+	delete(m.db.authenticationTokens, string(generateHash(token)))
+
+	return nil
+}
+
+type memoryIPRuleModel struct {
+	db   *memoryDB
+	inTx bool
+}
+
+func (m memoryIPRuleModel) Insert(ctx context.Context, rule *IPRule) error {
+	rule.Network = rule.Network.Masked()
+
+	err := rule.Validate()
+	if err != nil {
+		return err
+	}
+
+	defer m.db.lockWrite(m.inTx)()
+
+	// Replace the rule for the same network, keeping its identity
+	rule.ID, rule.CreatedAt = uuid.Must(uuid.NewV4()), time.Now()
+	for id, existing := range m.db.ipRules {
+		if existing.Network == rule.Network {
+			rule.ID, rule.CreatedAt = id, existing.CreatedAt
+		}
+	}
+
+	m.db.ipRules[rule.ID] = *rule
+
+	return nil
+}
+
+func (m memoryIPRuleModel) Match(ctx context.Context, addr netip.Addr) (*IPRule, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	rules := make([]IPRule, 0, len(m.db.ipRules))
+	for _, rule := range m.db.ipRules {
+		rules = append(rules, rule)
+	}
+
+	rule, ok := BestIPRule(rules, addr, time.Now())
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return &rule, nil
+}
+
+func (m memoryIPRuleModel) GetAll(ctx context.Context) ([]*IPRule, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	now := time.Now()
+
+	rules := []*IPRule{}
+	for _, rule := range m.db.ipRules {
+		if rule.ExpiresAt == nil || rule.ExpiresAt.After(now) {
+			rules = append(rules, &rule)
+		}
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].CreatedAt.After(rules[j].CreatedAt)
+	})
+
+	return rules, nil
+}
+
+func (m memoryIPRuleModel) Delete(ctx context.Context, id uuid.UUID) error {
+	defer m.db.lockWrite(m.inTx)()
+
+	if _, ok := m.db.ipRules[id]; !ok {
+		return ErrRecordNotFound
+	}
+
+	delete(m.db.ipRules, id)
+
+	return nil
+}
+
+func (m memoryIPRuleModel) Purge(ctx context.Context) error {
+	defer m.db.lockWrite(m.inTx)()
+
+	now := time.Now()
+	for id, rule := range m.db.ipRules {
+		if rule.ExpiresAt != nil && !rule.ExpiresAt.After(now) {
+			delete(m.db.ipRules, id)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,298 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestMemoryUsers(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	user, err := m.User.New(ctx, "jane@example.com", "pa55word!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Version != 1 || user.ID.IsNil() {
+		t.Errorf("unexpected inserted user: %+v", user)
+	}
+
+	_, err = m.User.New(ctx, "JANE@example.com", "pa55word!")
+	if !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("duplicate email: got %v; want %v", err, ErrDuplicateEmail)
+	}
+
+	_, err = m.User.GetForCredentials(ctx, "Jane@Example.com", "pa55word!")
+	if err != nil {
+		t.Errorf("credentials: %v", err)
+	}
+
+	_, err = m.User.GetForCredentials(ctx, "jane@example.com", "wrong")
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("wrong password: got %v; want %v", err, ErrInvalidCredentials)
+	}
+
+	// Optimistic locking
+	a, _ := m.User.GetForCredentials(ctx, "jane@example.com", "pa55word!")
+	b, _ := m.User.GetForCredentials(ctx, "jane@example.com", "pa55word!")
+
+	a.Email = "jane@example.org"
+	err = m.User.Update(ctx, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Version != 2 {
+		t.Errorf("version after update = %d; want 2", a.Version)
+	}
+
+	err = m.User.Update(ctx, b)
+	if !errors.Is(err, ErrEditConflict) {
+		t.Errorf("stale update: got %v; want %v", err, ErrEditConflict)
+	}
+
+	other, _ := m.User.New(ctx, "john@example.com", "pa55word!")
+	other.Email = "JANE@example.org"
+	err = m.User.Update(ctx, other)
+	if !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("update to taken email: got %v; want %v", err, ErrDuplicateEmail)
+	}
+}
+
+func TestMemoryTokens(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	user, err := m.User.New(ctx, "jane@example.com", "pa55word!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vt, err := m.VerificationToken.New(ctx, ScopePasswordReset, "jane@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.VerificationToken.Verify(ctx, vt.Plaintext, ScopePasswordReset, "Jane@example.com", nil)
+	if err != nil {
+		t.Errorf("verify: %v", err)
+	}
+
+	err = m.VerificationToken.Verify(ctx, vt.Plaintext, ScopeRegistration, "jane@example.com", nil)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("verify other scope: got %v; want %v", err, ErrRecordNotFound)
+	}
+
+	u, err := m.User.GetForVerificationToken(ctx, ScopePasswordReset, vt.Plaintext)
+	if err != nil || u.ID != user.ID {
+		t.Errorf("user for verification token: %v, %v", u, err)
+	}
+
+	// Expired tokens are found but rejected
+	expired := &VerificationToken{
+		Scope:  ScopeEmailChange,
+		Email:  "jane@example.org",
+		UserID: &user.ID,
+		Token:  &Token{Plaintext: "expired", Hash: generateHash("expired"), Expiry: time.Now().Add(-time.Minute)},
+	}
+	err = m.VerificationToken.Insert(ctx, expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.VerificationToken.Verify(ctx, "expired", ScopeEmailChange, "jane@example.org", &user.ID)
+	if !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("verify expired: got %v; want %v", err, ErrExpiredToken)
+	}
+
+	err = m.VerificationToken.PurgeWithUserID(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := m.VerificationToken.Exists(ctx, ScopeEmailChange, "jane@example.org", &user.ID); ok {
+		t.Error("token exists after purge")
+	}
+	if ok, _ := m.VerificationToken.Exists(ctx, ScopePasswordReset, "jane@example.com", nil); !ok {
+		t.Error("purge removed token without user")
+	}
+
+	at, err := m.AuthenticationToken.New(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err = m.User.GetForAuthenticationToken(ctx, at.Plaintext)
+	if err != nil || u.ID != user.ID {
+		t.Errorf("user for authentication token: %v, %v", u, err)
+	}
+
+	err = m.AuthenticationToken.Purge(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.User.GetForAuthenticationToken(ctx, at.Plaintext)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("purged authentication token: got %v; want %v", err, ErrRecordNotFound)
+	}
+}
+
+func TestMemoryWithTx(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	errRollback := errors.New("rollback")
+
+	err := m.WithTx(ctx, func(m Models) error {
+		_, err := m.User.New(ctx, "jane@example.com", "pa55word!")
+		if err != nil {
+			return err
+		}
+
+		return errRollback
+	})
+	if err != errRollback {
+		t.Fatalf("got %v; want %v", err, errRollback)
+	}
+
+	if ok, _ := m.User.ExistsWithEmail(ctx, "jane@example.com"); ok {
+		t.Error("user exists after rollback")
+	}
+
+	err = m.WithTx(ctx, func(m Models) error {
+		_, err := m.User.New(ctx, "jane@example.com", "pa55word!")
+		if err != nil {
+			return err
+		}
+
+		// Nested transactions roll back to a savepoint
+		m.WithTx(ctx, func(m Models) error {
+			m.User.New(ctx, "john@example.com", "pa55word!")
+			return errRollback
+		})
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := m.User.ExistsWithEmail(ctx, "jane@example.com"); !ok {
+		t.Error("committed user missing")
+	}
+	if ok, _ := m.User.ExistsWithEmail(ctx, "john@example.com"); ok {
+		t.Error("user exists after savepoint rollback")
+	}
+}
+
+func TestMemoryWithTxConcurrentWrite(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	// Hash up front so the insert lands while the transaction is open
+	user := &User{Email: "john@example.com"}
+	err := user.SetPasswordHash(ctx, "pa55word!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errRollback := errors.New("rollback")
+	started := make(chan struct{})
+	done := make(chan error)
+
+	go func() {
+		<-started
+		done <- m.User.Insert(ctx, user)
+	}()
+
+	m.WithTx(ctx, func(m Models) error {
+		close(started)
+
+		// Give the outside write a chance to run before rolling back
+		time.Sleep(10 * time.Millisecond)
+
+		return errRollback
+	})
+
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := m.User.ExistsWithEmail(ctx, "john@example.com"); !ok {
+		t.Error("write outside the transaction lost on rollback")
+	}
+}
+
+func TestMemoryIPRules(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	expired := time.Now().Add(-time.Minute)
+	rules := []*IPRule{
+		{Network: netip.MustParsePrefix("10.0.0.0/8"), Action: IPRuleDeny},
+		{Network: netip.MustParsePrefix("10.1.0.0/16"), Action: IPRuleAllow},
+		{Network: netip.MustParsePrefix("10.1.2.3/32"), Action: IPRuleDeny},
+	}
+	for _, rule := range rules {
+		err := m.IPRule.Insert(ctx, rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"10.9.9.9", IPRuleDeny},
+		{"10.1.9.9", IPRuleAllow},
+		{"10.1.2.3", IPRuleDeny},
+		{"::ffff:10.1.9.9", IPRuleAllow},
+		{"192.0.2.1", ""},
+	}
+	for _, tt := range tests {
+		rule, err := m.IPRule.Match(ctx, netip.MustParseAddr(tt.addr))
+		switch {
+		case tt.want == "" && !errors.Is(err, ErrRecordNotFound):
+			t.Errorf("%s: got %v, %v; want ErrRecordNotFound", tt.addr, rule, err)
+		case tt.want != "" && (err != nil || rule.Action != tt.want):
+			t.Errorf("%s: got %v, %v; want %s", tt.addr, rule, err, tt.want)
+		}
+	}
+
+	// Expired rules can't be inserted, and are ignored once they expire
+	err := m.IPRule.Insert(ctx, &IPRule{Network: rules[2].Network, Action: IPRuleDeny, ExpiresAt: &expired})
+	if err == nil {
+		t.Error("inserted expired rule")
+	}
+
+	m.IPRule.(memoryIPRuleModel).db.ipRules[rules[2].ID] = IPRule{
+		ID:        rules[2].ID,
+		Network:   rules[2].Network,
+		Action:    IPRuleDeny,
+		ExpiresAt: &expired,
+	}
+
+	rule, err := m.IPRule.Match(ctx, netip.MustParseAddr("10.1.2.3"))
+	if err != nil || rule.Action != IPRuleAllow {
+		t.Errorf("expired rule matched: %v, %v", rule, err)
+	}
+
+	all, err := m.IPRule.GetAll(ctx)
+	if err != nil || len(all) != 2 {
+		t.Errorf("GetAll: got %d rules, %v; want 2", len(all), err)
+	}
+
+	err = m.IPRule.Purge(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.IPRule.Delete(ctx, rules[2].ID)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("Delete purged rule: got %v", err)
+	}
+}
@@ -1,30 +1,130 @@
 package data
 
 import (
+	"context"
 	"errors"
+	"net/netip"
 	"time"
 
 	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 const ctxTimeout = 3 * time.Second
 
+// Implemented by both *pgxpool.Pool and pgx.Tx, so models can run
+// queries inside or outside of a transaction.
+type querier interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Stores for users, their tokens and IP rules. UserModel and friends implement
+// them with PostgreSQL, NewMemory returns in-memory implementations.
+type (
+	UserStore interface {
+		New(ctx context.Context, email, password string) (*User, error)
+		Insert(ctx context.Context, user *User) error
+		GetForCredentials(ctx context.Context, email, password string) (*User, error)
+		GetForAuthenticationToken(ctx context.Context, token string) (*User, error)
+		GetForVerificationToken(ctx context.Context, scope, token string) (*User, error)
+		GetIDForEmail(ctx context.Context, email string) (uuid.UUID, error)
+		ExistsWithEmail(ctx context.Context, email string) (bool, error)
+		Update(ctx context.Context, user *User) error
+	}
+
+	VerificationTokenStore interface {
+		New(ctx context.Context, scope, email string, userID *uuid.UUID) (*Token, error)
+		Insert(ctx context.Context, vt *VerificationToken) error
+		Exists(ctx context.Context, scope, email string, userID *uuid.UUID) (bool, error)
+		PurgeWithEmail(ctx context.Context, email string) error
+		PurgeWithUserID(ctx context.Context, userID uuid.UUID) error
+		Verify(ctx context.Context, token, scope, email string, userID *uuid.UUID) error
+	}
+
+	AuthenticationTokenStore interface {
+		New(ctx context.Context, userID uuid.UUID) (*Token, error)
+		Insert(ctx context.Context, t *AuthenticationToken) error
+		Purge(ctx context.Context, userID uuid.UUID) error
+		Delete(ctx context.Context, token string) error
+	}
+
+	IPRuleStore interface {
+		Insert(ctx context.Context, rule *IPRule) error
+		Match(ctx context.Context, addr netip.Addr) (*IPRule, error)
+		GetAll(ctx context.Context) ([]*IPRule, error)
+		Delete(ctx context.Context, id uuid.UUID) error
+		Purge(ctx context.Context) error
+	}
+)
+
+var (
+	_ UserStore                = UserModel{}
+	_ VerificationTokenStore   = VerificationTokenModel{}
+	_ AuthenticationTokenStore = AuthenticationTokenModel{}
+	_ IPRuleStore              = IPRuleModel{}
+	_ UserStore                = memoryUserModel{}
+	_ VerificationTokenStore   = memoryVerificationTokenModel{}
+	_ AuthenticationTokenStore = memoryAuthenticationTokenModel{}
+	_ IPRuleStore              = memoryIPRuleModel{}
+)
+
 type Models struct {
-	User                UserModel
-	VerificationToken   VerificationTokenModel
-	AuthenticationToken AuthenticationTokenModel
+	User                UserStore
+	VerificationToken   VerificationTokenStore
+	AuthenticationToken AuthenticationTokenStore
+	IPRule              IPRuleStore
+	MailSuppression     MailSuppressionModel
+	MailSend            MailSendModel
+	RateLimit           RateLimitModel
+
+	withTx func(ctx context.Context, fn func(Models) error) error
 }
 
 func New(pool *pgxpool.Pool) Models {
+	return newModels(pool)
+}
+
+func newModels(db querier) Models {
 	return Models{
-		User:                UserModel{pool},
-		VerificationToken:   VerificationTokenModel{pool},
-		AuthenticationToken: AuthenticationTokenModel{pool},
+		User:                UserModel{db},
+		VerificationToken:   VerificationTokenModel{db},
+		AuthenticationToken: AuthenticationTokenModel{db},
+		IPRule:              IPRuleModel{db},
+		MailSuppression:     MailSuppressionModel{db},
+		MailSend:            MailSendModel{db},
+		RateLimit:           RateLimitModel{db},
+		withTx: func(ctx context.Context, fn func(Models) error) error {
+			tx, err := db.Begin(ctx)
+			if err != nil {
+				return err
+			}
+			// Rollback is a no-op after commit
+			defer tx.Rollback(context.Background())
+
+			err = fn(newModels(tx))
+			if err != nil {
+				return err
+			}
+
+			return tx.Commit(ctx)
+		},
 	}
 }
 
+// Run fn with models sharing a single transaction, which is committed
+// if fn returns nil and rolled back otherwise. The error from fn is
+// returned unchanged. Calling WithTx on the models passed to fn
+// creates a savepoint.
+func (m Models) WithTx(ctx context.Context, fn func(Models) error) error {
+	return m.withTx(ctx, fn)
+}
+
 // Validation rules
 var (
 	PasswordLength = validation.Length(8, 72)
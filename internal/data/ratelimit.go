@@ -0,0 +1,105 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/micahco/api/internal/ratelimit"
+)
+
+// Sliding window request counts shared by every server, so rate
+// limits hold across replicas and restarts.
+type RateLimitModel struct {
+	db querier
+}
+
+var _ ratelimit.Store = RateLimitModel{}
+
+// Count the request in the current fixed window and estimate the
+// sliding window count by weighting the previous window by how much
+// of it still overlaps. Rejected requests are counted too, so clients
+// must slow down to recover.
+func (m RateLimitModel) Allow(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	return m.allowAt(ctx, key, limit, time.Now())
+}
+
+func (m RateLimitModel) allowAt(ctx context.Context, key string, limit ratelimit.Limit, now time.Time) (ratelimit.Result, error) {
+	window := limit.Window()
+	if window <= 0 {
+		return ratelimit.Result{}, fmt.Errorf("rate limit: invalid limit %+v", limit)
+	}
+
+	index := now.UnixNano() / int64(window)
+	elapsed := float64(now.UnixNano()%int64(window)) / float64(window)
+	expiry := time.Unix(0, (index+2)*int64(window))
+
+	// Limits may change on reload, keep their counts separate
+	key = fmt.Sprintf("%s:%d", key, window.Milliseconds())
+
+	sql := `
+		WITH current AS (
+			INSERT INTO rate_limit_ (key_, window_, count_, expires_at_)
+			VALUES($1, $2, 1, $3)
+			ON CONFLICT (key_, window_) DO UPDATE
+			SET count_ = rate_limit_.count_ + 1
+			RETURNING count_
+		)
+		SELECT current.count_, COALESCE((
+			SELECT count_ FROM rate_limit_
+			WHERE key_ = $1 AND window_ = $2 - 1
+		), 0)
+		FROM current;`
+
+	args := []any{key, index, expiry}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	var current, previous int
+	err := m.db.QueryRow(ctx, sql, args...).Scan(&current, &previous)
+	if err != nil {
+		return ratelimit.Result{}, err
+	}
+
+	burst := float64(limit.Burst)
+	estimate := float64(previous)*(1-elapsed) + float64(current)
+
+	res := ratelimit.Result{
+		Allowed:   estimate <= burst,
+		Remaining: max(int(burst-estimate), 0),
+	}
+	if !res.Allowed {
+		res.RetryAfter = retryAfter(burst, float64(previous), float64(current), elapsed, window)
+	}
+
+	return res, nil
+}
+
+// Estimate the time until another request is allowed as the previous
+// window slides out of range. When the current window is already full
+// that is at least until it ends.
+func retryAfter(burst, previous, current, elapsed float64, window time.Duration) time.Duration {
+	if current < burst && previous > 0 {
+		// Solve previous*(1-e) + current + 1 <= burst for e
+		e := 1 - (burst-current-1)/previous
+		if e > elapsed && e < 1 {
+			return time.Duration((e - elapsed) * float64(window))
+		}
+	}
+
+	return time.Duration((1 - elapsed) * float64(window))
+}
+
+// Delete windows which no longer overlap the sliding window.
+func (m RateLimitModel) Purge(ctx context.Context) error {
+	sql := `
+		DELETE FROM rate_limit_
+		WHERE expires_at_ < NOW();`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.Exec(ctx, sql)
+	return err
+}
@@ -0,0 +1,85 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/micahco/api/internal/ratelimit"
+)
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name                     string
+		burst, previous, current float64
+		elapsed                  float64
+		want                     time.Duration
+	}{
+		// Rejected requests count, so a full current window only
+		// frees up when it ends
+		{"burst exhausted", 2, 0, 3, 0, 2 * time.Second},
+		{"burst exhausted late in window", 2, 0, 2, 0.75, 500 * time.Millisecond},
+		{"window boundary", 4, 4, 1, 0, time.Second},
+		{"previous window slides out", 4, 4, 2, 0.25, time.Second},
+		{"previous window slides out at window end", 2, 3, 1, 0.5, time.Second},
+		{"elapsed past previous window sliding out", 4, 4, 1, 0.75, 500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := retryAfter(tt.burst, tt.previous, tt.current, tt.elapsed, 2*time.Second)
+			if got != tt.want {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitAllow(t *testing.T) {
+	pool := newTestPool(t)
+	m := RateLimitModel{pool}
+
+	prefix := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		pool.Exec(context.Background(), `DELETE FROM rate_limit_ WHERE key_ LIKE $1;`, prefix+"%")
+	})
+
+	// Two requests per two second window
+	limit := ratelimit.Limit{Rate: 1, Burst: 2}
+	window := limit.Window()
+
+	// Start of the current window, so elapsed is exact
+	start := time.Unix(0, time.Now().UnixNano()/int64(window)*int64(window))
+
+	tests := []struct {
+		name   string
+		key    string
+		offset time.Duration
+		want   ratelimit.Result
+	}{
+		{"first", "a", 0, ratelimit.Result{Allowed: true, Remaining: 1}},
+		{"burst", "a", 0, ratelimit.Result{Allowed: true}},
+		{"exhausted", "a", 0, ratelimit.Result{RetryAfter: window}},
+		// Three requests from the previous window still weigh 1.5
+		{"half previous", "a", window + window/2, ratelimit.Result{RetryAfter: window / 2}},
+		// Two windows on the earlier counts no longer overlap
+		{"rolled over", "a", 3 * window, ratelimit.Result{Allowed: true, Remaining: 1}},
+		{"other key", "b", 0, ratelimit.Result{Allowed: true, Remaining: 1}},
+		{"other key burst", "b", 0, ratelimit.Result{Allowed: true}},
+		// Two requests from the previous window weigh 1
+		{"other key half previous", "b", window + window/2, ratelimit.Result{Allowed: true}},
+		{"other key half previous exhausted", "b", window + window/2, ratelimit.Result{RetryAfter: window / 2}},
+	}
+
+	for _, tt := range tests {
+		got, err := m.allowAt(context.Background(), prefix+tt.key, limit, start.Add(tt.offset))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if got != tt.want {
+			t.Errorf("%s: got %+v; want %+v", tt.name, got, tt.want)
+		}
+	}
+}
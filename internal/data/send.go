@@ -0,0 +1,95 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// Log of outgoing mail used to enforce per-recipient and
+// per-domain send budgets across restarts.
+type MailSendModel struct {
+	db querier
+}
+
+// SendLimit is the maximum number of messages that may be sent to a
+// single recipient and to a single domain within Window. A zero
+// value disables that half of the limit.
+type SendLimit struct {
+	Window    time.Duration
+	Recipient int
+	Domain    int
+}
+
+// Record a send to recipient unless recipient or domain has already
+// spent the budget of one of limits. Sends to the same domain are
+// serialized with an advisory lock held until the transaction ends,
+// so concurrent senders on any server can't both take the last send.
+func (m MailSendModel) InsertWithinLimits(ctx context.Context, recipient, domain string, limits []SendLimit) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	// Rollback is a no-op after commit
+	defer tx.Rollback(context.Background())
+
+	_, err = tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1));`, "mail_send:"+domain)
+	if err != nil {
+		return false, err
+	}
+
+	sql := `
+		SELECT COUNT(*) FILTER (WHERE recipient_ = $1), COUNT(*)
+		FROM mail_send_
+		WHERE domain_ = $2
+		AND sent_at_ > $3;`
+
+	now := time.Now()
+
+	for _, l := range limits {
+		var recipientCount, domainCount int
+
+		err = tx.QueryRow(ctx, sql, recipient, domain, now.Add(-l.Window)).Scan(&recipientCount, &domainCount)
+		if err != nil {
+			return false, err
+		}
+
+		if l.Recipient > 0 && recipientCount >= l.Recipient {
+			return false, nil
+		}
+		if l.Domain > 0 && domainCount >= l.Domain {
+			return false, nil
+		}
+	}
+
+	sql = `
+		INSERT INTO mail_send_ (recipient_, domain_)
+		VALUES($1, $2);`
+
+	_, err = tx.Exec(ctx, sql, recipient, domain)
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Delete sends older than t which no longer count towards any budget.
+func (m MailSendModel) PurgeBefore(ctx context.Context, t time.Time) error {
+	sql := `
+		DELETE FROM mail_send_
+		WHERE sent_at_ < $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.Exec(ctx, sql, t)
+	return err
+}
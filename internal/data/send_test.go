@@ -0,0 +1,81 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMailSendInsertWithinLimits(t *testing.T) {
+	pool := newTestPool(t)
+	m := MailSendModel{pool}
+
+	domain := fmt.Sprintf("send-%d.example.com", time.Now().UnixNano())
+	t.Cleanup(func() {
+		pool.Exec(context.Background(), `DELETE FROM mail_send_ WHERE domain_ = $1;`, domain)
+	})
+
+	limits := []SendLimit{{Window: time.Hour, Recipient: 2, Domain: 3}}
+
+	// Two recipients could take four sends between them, but the
+	// domain budget allows only three however the sends interleave
+	var (
+		wg   sync.WaitGroup
+		sent atomic.Int32
+	)
+	for i := range 20 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			recipient := fmt.Sprintf("user%d@%s", i%2, domain)
+			ok, err := m.InsertWithinLimits(context.Background(), recipient, domain, limits)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if ok {
+				sent.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := sent.Load(); n != 3 {
+		t.Errorf("recorded %d sends; want 3", n)
+	}
+
+	var count int
+	err := pool.QueryRow(context.Background(), `SELECT COUNT(*) FROM mail_send_ WHERE domain_ = $1;`, domain).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("logged %d sends; want 3", count)
+	}
+
+	// Sends older than every window are purged, recent ones are kept
+	_, err = pool.Exec(context.Background(), `
+		INSERT INTO mail_send_ (recipient_, domain_, sent_at_)
+		VALUES($1, $2, NOW() - INTERVAL '2 hours');`, "user0@"+domain, domain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.PurgeBefore(context.Background(), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pool.QueryRow(context.Background(), `SELECT COUNT(*) FROM mail_send_ WHERE domain_ = $1;`, domain).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("after purge: %d sends; want 3", count)
+	}
+}
@@ -0,0 +1,130 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-ozzo/ozzo-validation/is"
+)
+
+const (
+	SuppressionReasonBounce    = "bounce"
+	SuppressionReasonComplaint = "complaint"
+	SuppressionReasonManual    = "manual"
+)
+
+type MailSuppressionModel struct {
+	db querier
+}
+
+type MailSuppression struct {
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	Reason    string    `json:"reason"`
+	Detail    string    `json:"detail"`
+}
+
+func (ms MailSuppression) Validate() error {
+	return validation.ValidateStruct(&ms,
+		validation.Field(&ms.Email, validation.Required, is.Email),
+		validation.Field(&ms.Reason, validation.Required, validation.In(
+			SuppressionReasonBounce,
+			SuppressionReasonComplaint,
+			SuppressionReasonManual,
+		)))
+}
+
+// Insert suppression for email. An existing suppression for the
+// same address is replaced with the latest reason and detail.
+func (m MailSuppressionModel) Insert(ctx context.Context, ms *MailSuppression) error {
+	err := ms.Validate()
+	if err != nil {
+		return err
+	}
+
+	sql := `
+		INSERT INTO mail_suppression_ (email_, reason_, detail_)
+		VALUES($1, $2, $3)
+		ON CONFLICT (email_) DO UPDATE
+		SET reason_ = EXCLUDED.reason_, detail_ = EXCLUDED.detail_
+		RETURNING created_at_;`
+
+	args := []any{ms.Email, ms.Reason, ms.Detail}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	return m.db.QueryRow(ctx, sql, args...).Scan(&ms.CreatedAt)
+}
+
+func (m MailSuppressionModel) Exists(ctx context.Context, email string) (bool, error) {
+	var exists bool
+
+	sql := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM mail_suppression_
+			WHERE email_ = $1
+		);`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.QueryRow(ctx, sql, email).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+func (m MailSuppressionModel) GetAll(ctx context.Context) ([]*MailSuppression, error) {
+	sql := `
+		SELECT email_, created_at_, reason_, detail_
+		FROM mail_suppression_
+		ORDER BY created_at_ DESC;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	rows, err := m.db.Query(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suppressions := []*MailSuppression{}
+	for rows.Next() {
+		var ms MailSuppression
+
+		err := rows.Scan(&ms.Email, &ms.CreatedAt, &ms.Reason, &ms.Detail)
+		if err != nil {
+			return nil, err
+		}
+
+		suppressions = append(suppressions, &ms)
+	}
+
+	return suppressions, rows.Err()
+}
+
+func (m MailSuppressionModel) Delete(ctx context.Context, email string) error {
+	sql := `
+		DELETE FROM mail_suppression_
+		WHERE email_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	result, err := m.db.Exec(ctx, sql, email)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package data
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Connect to the database at API_TEST_DB_DSN, which must have all
+// migrations applied, or skip the test if it isn't set.
+func newTestPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	dsn := os.Getenv("API_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("API_TEST_DB_DSN not set")
+	}
+
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	return pool
+}
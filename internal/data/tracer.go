@@ -0,0 +1,57 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/micahco/api/internal/data")
+
+// QueryTracer records a client span for every query run through a
+// pgx connection. Set it as the ConnConfig.Tracer of the pool.
+type QueryTracer struct{}
+
+type querySpanKey struct{}
+
+func (QueryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, span := tracer.Start(ctx, queryName(data.SQL),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBQueryText(strings.TrimSpace(data.SQL)),
+		),
+	)
+
+	return context.WithValue(ctx, querySpanKey{}, span)
+}
+
+func (QueryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	span, ok := ctx.Value(querySpanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	defer span.End()
+
+	// No rows is an expected result rather than a failed query
+	if data.Err != nil && !errors.Is(data.Err, pgx.ErrNoRows) {
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+	}
+}
+
+// Span name from the SQL statement, e.g. "SELECT".
+func queryName(sql string) string {
+	name, _, _ := strings.Cut(strings.TrimSpace(sql), " ")
+	if name == "" {
+		return "query"
+	}
+
+	return strings.ToUpper(name)
+}
@@ -11,11 +11,10 @@ import (
 	"github.com/gofrs/uuid/v5"
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type UserModel struct {
-	pool *pgxpool.Pool
+	db querier
 }
 
 type User struct {
@@ -24,6 +23,7 @@ type User struct {
 	Email        string    `json:"email"`
 	PasswordHash []byte    `json:"-"`
 	Version      int       `json:"-"`
+	Admin        bool      `json:"-"`
 }
 
 var AnonymousUser = &User{}
@@ -38,8 +38,8 @@ func (u User) Validate() error {
 		validation.Field(&u.PasswordHash, validation.Required))
 }
 
-func (u *User) SetPasswordHash(password string) error {
-	hash, err := argon2id.CreateHash(password, argon2id.DefaultParams)
+func (u *User) SetPasswordHash(ctx context.Context, password string) error {
+	hash, err := createHash(ctx, password)
 	if err != nil {
 		return err
 	}
@@ -49,15 +49,15 @@ func (u *User) SetPasswordHash(password string) error {
 	return nil
 }
 
-func (m UserModel) New(email, password string) (*User, error) {
+func (m UserModel) New(ctx context.Context, email, password string) (*User, error) {
 	user := &User{Email: email}
 
-	err := user.SetPasswordHash(password)
+	err := user.SetPasswordHash(ctx, password)
 	if err != nil {
 		return nil, err
 	}
 
-	err = m.Insert(user)
+	err = m.Insert(ctx, user)
 	if err != nil {
 		return nil, err
 	}
@@ -65,7 +65,7 @@ func (m UserModel) New(email, password string) (*User, error) {
 	return user, nil
 }
 
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
 	err := user.Validate()
 	if err != nil {
 		return err
@@ -78,10 +78,10 @@ func (m UserModel) Insert(user *User) error {
 
 	args := []any{user.Email, user.PasswordHash}
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	err = m.pool.QueryRow(ctx, sql, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	err = m.db.QueryRow(ctx, sql, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
 		switch {
 		case pgErrCode(err) == pgerrcode.UniqueViolation:
@@ -94,22 +94,23 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetForCredentials(email, password string) (*User, error) {
+func (m UserModel) GetForCredentials(ctx context.Context, email, password string) (*User, error) {
 	var u User
 
 	sql := `
-		SELECT id_, created_at_, email_, password_hash_, version_
+		SELECT id_, created_at_, email_, password_hash_, version_, admin_
 		FROM user_ WHERE email_ = $1;`
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	err := m.pool.QueryRow(ctx, sql, email).Scan(
+	err := m.db.QueryRow(ctx, sql, email).Scan(
 		&u.ID,
 		&u.CreatedAt,
 		&u.Email,
 		&u.PasswordHash,
 		&u.Version,
+		&u.Admin,
 	)
 	if err != nil {
 		switch {
@@ -120,7 +121,7 @@ func (m UserModel) GetForCredentials(email, password string) (*User, error) {
 		}
 	}
 
-	match, err := argon2id.ComparePasswordAndHash(password, string(u.PasswordHash))
+	match, err := comparePasswordAndHash(ctx, password, string(u.PasswordHash))
 	if err != nil {
 		return nil, err
 	}
@@ -131,13 +132,13 @@ func (m UserModel) GetForCredentials(email, password string) (*User, error) {
 	return &u, nil
 }
 
-func (m UserModel) GetForAuthenticationToken(token string) (*User, error) {
+func (m UserModel) GetForAuthenticationToken(ctx context.Context, token string) (*User, error) {
 	var u User
 	var expiry time.Time
 
 	sql := `
 		SELECT user_.id_, user_.created_at_, user_.email_, user_.password_hash_, 
-		user_.version_, authentication_token_.expiry_
+		user_.version_, user_.admin_, authentication_token_.expiry_
 		FROM user_
 		INNER JOIN authentication_token_
 		ON user_.id_ = authentication_token_.user_id_
@@ -145,15 +146,16 @@ func (m UserModel) GetForAuthenticationToken(token string) (*User, error) {
 
 	hash := generateHash(token)
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	err := m.pool.QueryRow(ctx, sql, hash).Scan(
+	err := m.db.QueryRow(ctx, sql, hash).Scan(
 		&u.ID,
 		&u.CreatedAt,
 		&u.Email,
 		&u.PasswordHash,
 		&u.Version,
+		&u.Admin,
 		&expiry,
 	)
 	if err != nil {
@@ -172,13 +174,13 @@ func (m UserModel) GetForAuthenticationToken(token string) (*User, error) {
 	return &u, nil
 }
 
-func (m UserModel) GetForVerificationToken(scope, token string) (*User, error) {
+func (m UserModel) GetForVerificationToken(ctx context.Context, scope, token string) (*User, error) {
 	var u User
 	var expiry time.Time
 
 	sql := `
 		SELECT user_.id_, user_.created_at_, user_.email_, user_.password_hash_,
-		user_.version_, verification_token_.expiry_
+		user_.version_, user_.admin_, verification_token_.expiry_
 		FROM user_
 		INNER JOIN verification_token_
 		ON user_.email_ = verification_token_.email_
@@ -188,15 +190,16 @@ func (m UserModel) GetForVerificationToken(scope, token string) (*User, error) {
 	hash := generateHash(token)
 	args := []any{scope, hash}
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	err := m.pool.QueryRow(ctx, sql, args...).Scan(
+	err := m.db.QueryRow(ctx, sql, args...).Scan(
 		&u.ID,
 		&u.CreatedAt,
 		&u.Email,
 		&u.PasswordHash,
 		&u.Version,
+		&u.Admin,
 		&expiry,
 	)
 	if err != nil {
@@ -215,27 +218,31 @@ func (m UserModel) GetForVerificationToken(scope, token string) (*User, error) {
 	return &u, nil
 }
 
-func (m UserModel) GetIDForEmail(email string) (uuid.UUID, error) {
+func (m UserModel) GetIDForEmail(ctx context.Context, email string) (uuid.UUID, error) {
 	var id uuid.UUID
 
 	sql := `
 		SELECT user_.id_
 		FROM user_
-		WHERE email_ = $1l	
-	`
+		WHERE email_ = $1;`
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	err := m.pool.QueryRow(ctx, sql, email).Scan(&id)
+	err := m.db.QueryRow(ctx, sql, email).Scan(&id)
 	if err != nil {
-		return id, err
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return id, ErrRecordNotFound
+		default:
+			return id, err
+		}
 	}
 
 	return id, nil
 }
 
-func (m UserModel) ExistsWithEmail(email string) (bool, error) {
+func (m UserModel) ExistsWithEmail(ctx context.Context, email string) (bool, error) {
 	var exists bool
 
 	sql := `
@@ -245,10 +252,10 @@ func (m UserModel) ExistsWithEmail(email string) (bool, error) {
 			WHERE email_ = $1
 		);`
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	err := m.pool.QueryRow(ctx, sql, email).Scan(&exists)
+	err := m.db.QueryRow(ctx, sql, email).Scan(&exists)
 	if err != nil {
 		return false, err
 	}
@@ -256,7 +263,7 @@ func (m UserModel) ExistsWithEmail(email string) (bool, error) {
 	return exists, nil
 }
 
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
 	err := user.Validate()
 	if err != nil {
 		return err
@@ -275,10 +282,10 @@ func (m UserModel) Update(user *User) error {
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	err = m.pool.QueryRow(ctx, sql, args...).Scan(&user.Version)
+	err = m.db.QueryRow(ctx, sql, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, pgx.ErrNoRows):
@@ -292,3 +299,18 @@ func (m UserModel) Update(user *User) error {
 
 	return nil
 }
+
+// Password hashing is deliberately slow, so trace it alongside queries.
+func createHash(ctx context.Context, password string) (string, error) {
+	_, span := tracer.Start(ctx, "argon2id.CreateHash")
+	defer span.End()
+
+	return argon2id.CreateHash(password, argon2id.DefaultParams)
+}
+
+func comparePasswordAndHash(ctx context.Context, password, hash string) (bool, error) {
+	_, span := tracer.Start(ctx, "argon2id.ComparePasswordAndHash")
+	defer span.End()
+
+	return argon2id.ComparePasswordAndHash(password, hash)
+}
@@ -9,7 +9,6 @@ import (
 	"github.com/go-ozzo/ozzo-validation/is"
 	"github.com/gofrs/uuid/v5"
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 const (
@@ -21,7 +20,7 @@ const (
 )
 
 type VerificationTokenModel struct {
-	pool *pgxpool.Pool
+	db querier
 }
 
 type VerificationToken struct {
@@ -41,7 +40,7 @@ func (vt VerificationToken) Validate() error {
 // Create and insert new verification for email. Generates a randomly
 // generated token and stores a hash of it in the database. Returns
 // the plaintext token.
-func (m VerificationTokenModel) New(scope, email string, userID *uuid.UUID) (*Token, error) {
+func (m VerificationTokenModel) New(ctx context.Context, scope, email string, userID *uuid.UUID) (*Token, error) {
 	t, err := generateToken(VerificationTokenTTL)
 	if err != nil {
 		return nil, err
@@ -54,7 +53,7 @@ func (m VerificationTokenModel) New(scope, email string, userID *uuid.UUID) (*To
 		Token:  t,
 	}
 
-	err = m.Insert(vt)
+	err = m.Insert(ctx, vt)
 	if err != nil {
 		return nil, err
 	}
@@ -62,13 +61,13 @@ func (m VerificationTokenModel) New(scope, email string, userID *uuid.UUID) (*To
 	return t, err
 }
 
-func (m VerificationTokenModel) Insert(vt *VerificationToken) error {
+func (m VerificationTokenModel) Insert(ctx context.Context, vt *VerificationToken) error {
 	err := vt.Validate()
 	if err != nil {
 		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
 	sql := `
@@ -77,11 +76,11 @@ func (m VerificationTokenModel) Insert(vt *VerificationToken) error {
 
 	args := []any{vt.Hash, vt.Expiry, vt.Scope, vt.Email, vt.UserID}
 
-	_, err = m.pool.Exec(ctx, sql, args...)
+	_, err = m.db.Exec(ctx, sql, args...)
 	return err
 }
 
-func (m VerificationTokenModel) Exists(scope, email string, userID *uuid.UUID) (bool, error) {
+func (m VerificationTokenModel) Exists(ctx context.Context, scope, email string, userID *uuid.UUID) (bool, error) {
 	var exists bool
 
 	sql := `
@@ -101,10 +100,10 @@ func (m VerificationTokenModel) Exists(scope, email string, userID *uuid.UUID) (
 	sql += `
 		);`
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	err := m.pool.QueryRow(ctx, sql, args...).Scan(&exists)
+	err := m.db.QueryRow(ctx, sql, args...).Scan(&exists)
 	if err != nil {
 		return false, err
 	}
@@ -112,31 +111,31 @@ func (m VerificationTokenModel) Exists(scope, email string, userID *uuid.UUID) (
 	return exists, nil
 }
 
-func (m VerificationTokenModel) PurgeWithEmail(email string) error {
+func (m VerificationTokenModel) PurgeWithEmail(ctx context.Context, email string) error {
 	sql := `
 		DELETE FROM verification_token_
 		WHERE email_ = $1;`
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	_, err := m.pool.Exec(ctx, sql, email)
+	_, err := m.db.Exec(ctx, sql, email)
 	return err
 }
 
-func (m VerificationTokenModel) PurgeWithUserID(userID uuid.UUID) error {
+func (m VerificationTokenModel) PurgeWithUserID(ctx context.Context, userID uuid.UUID) error {
 	sql := `
 		DELETE FROM verification_token_
 		WHERE user_id_ = $1;`
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	_, err := m.pool.Exec(ctx, sql, userID)
+	_, err := m.db.Exec(ctx, sql, userID)
 	return err
 }
 
-func (m VerificationTokenModel) Verify(token, scope, email string, userID *uuid.UUID) error {
+func (m VerificationTokenModel) Verify(ctx context.Context, token, scope, email string, userID *uuid.UUID) error {
 	var expiry time.Time
 
 	sql := `
@@ -155,10 +154,10 @@ func (m VerificationTokenModel) Verify(token, scope, email string, userID *uuid.
 		args = append(args, *userID)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	err := m.pool.QueryRow(ctx, sql, args...).Scan(&expiry)
+	err := m.db.QueryRow(ctx, sql, args...).Scan(&expiry)
 	if err != nil {
 		switch {
 		case errors.Is(err, pgx.ErrNoRows):
@@ -0,0 +1,57 @@
+package mailer
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Read a PEM encoded RSA or Ed25519 private key for DKIM signing.
+func LoadDKIMKey(path string) (crypto.Signer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseDKIMKey(b)
+}
+
+// Parse a PEM encoded PKCS #1 RSA key or PKCS #8 RSA/Ed25519 key.
+func ParseDKIMKey(b []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("dkim: no PEM block found in key")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		// Return nil rather than a nil *rsa.PrivateKey in the interface
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		return key, nil
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			return k, nil
+		case ed25519.PrivateKey:
+			return k, nil
+		default:
+			return nil, fmt.Errorf("dkim: unsupported key type %T", key)
+		}
+	default:
+		return nil, fmt.Errorf("dkim: unsupported PEM block type %q", block.Type)
+	}
+}
@@ -0,0 +1,77 @@
+package mailer
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// SendLog records outgoing mail so send budgets survive restarts.
+type SendLog interface {
+	// Record a send to recipient unless recipient or domain has
+	// already spent the budget of one of limits, reporting whether it
+	// was recorded. The check and the insert must be atomic so
+	// concurrent senders can't overspend a budget.
+	InsertWithinLimits(ctx context.Context, recipient, domain string, limits []SendLimit) (bool, error)
+	PurgeBefore(ctx context.Context, t time.Time) error
+}
+
+// SendLimit is the maximum number of messages that may be sent to a
+// single recipient and to a single domain within Window. A zero
+// value disables that half of the limit.
+type SendLimit struct {
+	Window    time.Duration
+	Recipient int
+	Domain    int
+}
+
+// Drop any message that would exceed one of the limits. Sends are
+// recorded in log.
+func (m *Mailer) SetSendLimits(log SendLog, limits ...SendLimit) {
+	m.sendLog = log
+	m.sendLimits = limits
+}
+
+// Report whether recipient is within every send budget and, if so,
+// record the send against them.
+func (m *Mailer) allow(ctx context.Context, recipient string) (bool, error) {
+	if m.sendLog == nil || len(m.sendLimits) == 0 {
+		return true, nil
+	}
+
+	domain := recipient
+	if i := strings.LastIndex(recipient, "@"); i >= 0 {
+		domain = recipient[i+1:]
+	}
+	domain = strings.ToLower(domain)
+
+	return m.sendLog.InsertWithinLimits(ctx, recipient, domain, m.sendLimits)
+}
+
+// Delete sends older than the longest window, which no longer count
+// towards any budget, every interval until ctx is done.
+func (m *Mailer) RunPurge(ctx context.Context, interval time.Duration, onError func(error)) {
+	if m.sendLog == nil || len(m.sendLimits) == 0 {
+		return
+	}
+
+	var maxWindow time.Duration
+	for _, l := range m.sendLimits {
+		maxWindow = max(maxWindow, l.Window)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := m.sendLog.PurgeBefore(ctx, time.Now().Add(-maxWindow))
+			if err != nil && ctx.Err() == nil {
+				onError(err)
+			}
+		}
+	}
+}
@@ -2,25 +2,125 @@ package mailer
 
 import (
 	"bytes"
-	"embed"
+	"context"
+	"crypto"
 	"fmt"
+	"io"
 	"io/fs"
+	"net"
 	"net/mail"
 	"path/filepath"
+	"strconv"
+	"sync"
 	"text/template"
+	"time"
 
+	"github.com/emersion/go-msgauth/dkim"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/gomail.v2"
 )
 
+// SuppressionList reports whether a recipient has been suppressed,
+// for example after a hard bounce or spam complaint.
+type SuppressionList interface {
+	Exists(ctx context.Context, email string) (bool, error)
+}
+
+var tracer = otel.Tracer("github.com/micahco/api/internal/mailer")
+
+// Outcome of a call to Send
+const (
+	OutcomeSent        = "sent"
+	OutcomeSuppressed  = "suppressed"
+	OutcomeRateLimited = "rate_limited"
+	OutcomeFailed      = "failed"
+)
+
+// How long the result of Ping is reused.
+const pingInterval = 30 * time.Second
+
 type Mailer struct {
-	dialer        *gomail.Dialer
+	mu            sync.RWMutex
+	dial          func() (gomail.SendCloser, error)
+	addr          string
 	sender        *mail.Address
 	templateCache map[string]*template.Template
+	dkim          *dkim.SignOptions
+	suppressions  SuppressionList
+	sendLog       SendLog
+	sendLimits    []SendLimit
+	observe       func(outcome string)
 	dev           bool
+
+	pingMu   sync.Mutex
+	pingAddr string
+	pingedAt time.Time
+	pingErr  error
+}
+
+// Transport delivers a rendered message to its recipients.
+type Transport interface {
+	Send(from string, to []string, msg io.WriterTo) error
+}
+
+type nopCloser struct {
+	Transport
+}
+
+func (nopCloser) Close() error {
+	return nil
 }
 
 // Create new mailer with SMTP credentials and embedded fs using glob pattern
-func New(dev bool, host string, port int, username string, password string, sender *mail.Address, fsys embed.FS, globPattern string) (*Mailer, error) {
+func New(dev bool, host string, port int, username string, password string, sender *mail.Address, fsys fs.FS, globPattern string) (*Mailer, error) {
+	cache, err := newTemplateCache(fsys, globPattern)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := gomail.NewDialer(host, port, username, password)
+
+	m := &Mailer{
+		dial:          dialer.Dial,
+		addr:          net.JoinHostPort(host, strconv.Itoa(port)),
+		sender:        sender,
+		templateCache: cache,
+		dev:           dev,
+	}
+
+	// Ping the SMTP server to verify authentication
+	s, err := m.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	return m, nil
+}
+
+// Create new mailer that delivers messages with transport instead of
+// an SMTP server, e.g. to capture outgoing mail in tests.
+func NewWithTransport(sender *mail.Address, fsys fs.FS, globPattern string, transport Transport) (*Mailer, error) {
+	cache, err := newTemplateCache(fsys, globPattern)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Mailer{
+		dial: func() (gomail.SendCloser, error) {
+			return nopCloser{transport}, nil
+		},
+		sender:        sender,
+		templateCache: cache,
+	}
+
+	return m, nil
+}
+
+func newTemplateCache(fsys fs.FS, globPattern string) (map[string]*template.Template, error) {
 	cache := map[string]*template.Template{}
 
 	// Get list of filenames in embed using pattern
@@ -41,51 +141,208 @@ func New(dev bool, host string, port int, username string, password string, send
 		cache[name] = t
 	}
 
-	m := &Mailer{
-		dialer:        gomail.NewDialer(host, port, username, password),
-		sender:        sender,
-		templateCache: cache,
-		dev:           dev,
+	return cache, nil
+}
+
+// Sign every outgoing message with a DKIM-Signature header for
+// domain using the key published under selector.
+func (m *Mailer) EnableDKIM(domain, selector string, key crypto.Signer) {
+	m.dkim = &dkim.SignOptions{
+		Domain:   domain,
+		Selector: selector,
+		Signer:   key,
+		HeaderKeys: []string{
+			"From", "To", "Subject", "Date", "Message-ID",
+			"Mime-Version", "Content-Type", "Content-Transfer-Encoding",
+		},
 	}
+}
 
-	// Ping the SMTP server to verify authentication
-	s, err := m.dialer.Dial()
+// Replace the SMTP server and sender used for subsequent messages.
+// Outside development the new server is dialed first and the current
+// settings are kept if that fails.
+func (m *Mailer) SetSMTP(host string, port int, username string, password string, sender *mail.Address) error {
+	dialer := gomail.NewDialer(host, port, username, password)
+
+	// Nothing is sent in development, so the server needn't be running
+	if !m.dev {
+		s, err := dialer.Dial()
+		if err != nil {
+			return err
+		}
+		s.Close()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.dial = dialer.Dial
+	m.addr = net.JoinHostPort(host, strconv.Itoa(port))
+	m.sender = sender
+
+	return nil
+}
+
+// Check the SMTP server accepts connections. Only a TCP connection is
+// made since credentials were checked when the server was configured,
+// and the result is reused for pingInterval so frequent readiness
+// probes don't each open an SMTP session.
+func (m *Mailer) Ping(ctx context.Context) error {
+	m.mu.RLock()
+	addr := m.addr
+	m.mu.RUnlock()
+
+	// Messages are delivered by a transport, or not sent at all in
+	// development
+	if addr == "" || m.dev {
+		return nil
+	}
+
+	m.pingMu.Lock()
+	defer m.pingMu.Unlock()
+
+	if m.pingAddr == addr && time.Since(m.pingedAt) < pingInterval {
+		return m.pingErr
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err == nil {
+		conn.Close()
+	}
+
+	// The caller giving up says nothing about the server
+	if ctx.Err() != nil {
+		return err
+	}
+
+	m.pingAddr, m.pingedAt, m.pingErr = addr, time.Now(), err
+
+	return err
+}
+
+// Call fn with the outcome of every Send, e.g. to count them.
+func (m *Mailer) SetObserver(fn func(outcome string)) {
+	m.observe = fn
+}
+
+// Skip sending to any recipient found in the suppression list.
+func (m *Mailer) SetSuppressionList(list SuppressionList) {
+	m.suppressions = list
+}
+
+func (m *Mailer) Send(ctx context.Context, recepient, tmpl string, data any) error {
+	ctx, span := tracer.Start(ctx, "mailer.Send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("mail.template", tmpl)),
+	)
+	defer span.End()
+
+	outcome, err := m.send(ctx, recepient, tmpl, data)
+
+	span.SetAttributes(attribute.String("mail.outcome", outcome))
 	if err != nil {
-		return nil, err
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 	}
-	defer s.Close()
 
-	return m, nil
+	if m.observe != nil {
+		m.observe(outcome)
+	}
+
+	return err
 }
 
-func (m *Mailer) Send(recepient, tmpl string, data any) error {
+func (m *Mailer) send(ctx context.Context, recepient, tmpl string, data any) (string, error) {
 	t, ok := m.templateCache[tmpl]
 	if !ok {
-		return fmt.Errorf("template %s does not exist", tmpl)
+		return OutcomeFailed, fmt.Errorf("template %s does not exist", tmpl)
+	}
+
+	if m.suppressions != nil {
+		suppressed, err := m.suppressions.Exists(ctx, recepient)
+		if err != nil {
+			return OutcomeFailed, err
+		}
+		if suppressed {
+			return OutcomeSuppressed, nil
+		}
+	}
+
+	// Over-limit sends are dropped without error, the same as
+	// suppressed recipients.
+	allowed, err := m.allow(ctx, recepient)
+	if err != nil {
+		return OutcomeFailed, err
+	}
+	if !allowed {
+		return OutcomeRateLimited, nil
 	}
 
 	subject := new(bytes.Buffer)
-	err := t.ExecuteTemplate(subject, "subject", data)
+	err = t.ExecuteTemplate(subject, "subject", data)
 	if err != nil {
-		return err
+		return OutcomeFailed, err
 	}
 
 	body := new(bytes.Buffer)
 	err = t.ExecuteTemplate(body, "body", data)
 	if err != nil {
-		return err
+		return OutcomeFailed, err
 	}
 
 	if m.dev {
 		fmt.Println(body.String())
-		return nil
+		return OutcomeSent, nil
 	}
 
+	m.mu.RLock()
+	dial, sender := m.dial, m.sender
+	m.mu.RUnlock()
+
 	msg := gomail.NewMessage()
 	msg.SetHeader("To", recepient)
-	msg.SetHeader("From", m.sender.String())
+	msg.SetHeader("From", sender.String())
 	msg.SetHeader("Subject", subject.String())
 	msg.SetBody("text/plain", body.String())
 
-	return m.dialer.DialAndSend(msg)
+	raw, err := m.render(msg)
+	if err != nil {
+		return OutcomeFailed, err
+	}
+
+	s, err := dial()
+	if err != nil {
+		return OutcomeFailed, err
+	}
+	defer s.Close()
+
+	err = s.Send(sender.Address, []string{recepient}, bytes.NewReader(raw))
+	if err != nil {
+		return OutcomeFailed, err
+	}
+
+	return OutcomeSent, nil
+}
+
+// Write the message in wire format, prepending a DKIM-Signature
+// header when signing is enabled.
+func (m *Mailer) render(msg *gomail.Message) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	_, err := msg.WriteTo(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.dkim == nil {
+		return buf.Bytes(), nil
+	}
+
+	signed := new(bytes.Buffer)
+	err = dkim.Sign(signed, bytes.NewReader(buf.Bytes()), m.dkim)
+	if err != nil {
+		return nil, fmt.Errorf("dkim: %w", err)
+	}
+
+	return signed.Bytes(), nil
 }
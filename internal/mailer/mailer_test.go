@@ -0,0 +1,420 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/emersion/go-msgauth/dkim"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+type captureSender struct {
+	from string
+	to   []string
+	msg  bytes.Buffer
+}
+
+func (c *captureSender) Send(from string, to []string, msg io.WriterTo) error {
+	c.from = from
+	c.to = to
+	_, err := msg.WriteTo(&c.msg)
+	return err
+}
+
+func newTestMailer(t *testing.T) (*Mailer, *captureSender) {
+	t.Helper()
+
+	fsys := fstest.MapFS{
+		"mail/test.tmpl": {Data: []byte(`{{define "subject"}}Hello{{end}}{{define "body"}}Token: {{.token}}{{end}}`)},
+	}
+
+	c := &captureSender{}
+	sender := &mail.Address{Name: "Do Not Reply", Address: "no-reply@example.com"}
+
+	m, err := NewWithTransport(sender, fsys, "mail/*.tmpl", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m, c
+}
+
+func TestSendDKIM(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		algo string
+		key  crypto.Signer
+	}{
+		{"ed25519", "ed25519", edKey},
+		{"rsa", "rsa", rsaKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, c := newTestMailer(t)
+			m.EnableDKIM("example.com", "mail", tt.key)
+
+			err := m.Send(context.Background(), "jane@example.org", "test.tmpl", map[string]any{"token": "ABC"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if c.from != "no-reply@example.com" || len(c.to) != 1 || c.to[0] != "jane@example.org" {
+				t.Fatalf("unexpected envelope: from %q to %v", c.from, c.to)
+			}
+			if !strings.HasPrefix(c.msg.String(), "DKIM-Signature:") {
+				t.Fatalf("message is missing DKIM-Signature header:\n%s", c.msg.String())
+			}
+
+			// Ed25519 records publish the raw key, RSA records the PKIX encoding.
+			var pub []byte
+			switch k := tt.key.Public().(type) {
+			case ed25519.PublicKey:
+				pub = k
+			default:
+				pub, err = x509.MarshalPKIXPublicKey(k)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			record := fmt.Sprintf("v=DKIM1; k=%s; p=%s", tt.algo, base64.StdEncoding.EncodeToString(pub))
+
+			verifications, err := dkim.VerifyWithOptions(bytes.NewReader(c.msg.Bytes()), &dkim.VerifyOptions{
+				LookupTXT: func(domain string) ([]string, error) {
+					if domain != "mail._domainkey.example.com" {
+						return nil, fmt.Errorf("unexpected lookup %q", domain)
+					}
+					return []string{record}, nil
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(verifications) != 1 {
+				t.Fatalf("got %d verifications; want 1", len(verifications))
+			}
+			if err := verifications[0].Err; err != nil {
+				t.Fatalf("signature did not verify: %v", err)
+			}
+		})
+	}
+}
+
+func TestSendUnsigned(t *testing.T) {
+	m, c := newTestMailer(t)
+
+	err := m.Send(context.Background(), "jane@example.org", "test.tmpl", map[string]any{"token": "ABC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(c.msg.String(), "DKIM-Signature") {
+		t.Fatal("unsigned mailer produced a DKIM-Signature header")
+	}
+	if !strings.Contains(c.msg.String(), "Token: ABC") {
+		t.Fatalf("message body missing rendered template:\n%s", c.msg.String())
+	}
+}
+
+func TestParseDKIMKey(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ParseDKIMKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := key.(ed25519.PrivateKey); !ok {
+		t.Fatalf("got %T; want ed25519.PrivateKey", key)
+	}
+
+	_, err = ParseDKIMKey([]byte("not a key"))
+	if err == nil {
+		t.Fatal("expected error for invalid PEM")
+	}
+
+	key, err = ParseDKIMKey(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: []byte("garbage")}))
+	if err == nil || key != nil {
+		t.Fatalf("invalid PKCS #1 key: got %#v, %v; want nil key and error", key, err)
+	}
+}
+
+type suppressionList map[string]bool
+
+func (l suppressionList) Exists(ctx context.Context, email string) (bool, error) {
+	return l[email], nil
+}
+
+func TestSendSuppressed(t *testing.T) {
+	m, c := newTestMailer(t)
+	m.SetSuppressionList(suppressionList{"bounced@example.org": true})
+
+	err := m.Send(context.Background(), "bounced@example.org", "test.tmpl", map[string]any{"token": "ABC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.msg.Len() != 0 {
+		t.Fatal("message was sent to a suppressed recipient")
+	}
+
+	err = m.Send(context.Background(), "jane@example.org", "test.tmpl", map[string]any{"token": "ABC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.msg.Len() == 0 {
+		t.Fatal("message was not sent to an unsuppressed recipient")
+	}
+}
+
+type sendRecord struct {
+	recipient string
+	domain    string
+	sentAt    time.Time
+}
+
+type memorySendLog struct {
+	records []sendRecord
+}
+
+func (l *memorySendLog) InsertWithinLimits(ctx context.Context, recipient, domain string, limits []SendLimit) (bool, error) {
+	now := time.Now()
+	for _, limit := range limits {
+		var r, d int
+		for _, rec := range l.records {
+			if rec.domain != domain || !rec.sentAt.After(now.Add(-limit.Window)) {
+				continue
+			}
+			d++
+			if rec.recipient == recipient {
+				r++
+			}
+		}
+
+		if limit.Recipient > 0 && r >= limit.Recipient {
+			return false, nil
+		}
+		if limit.Domain > 0 && d >= limit.Domain {
+			return false, nil
+		}
+	}
+
+	l.records = append(l.records, sendRecord{recipient, domain, now})
+	return true, nil
+}
+
+func (l *memorySendLog) PurgeBefore(ctx context.Context, t time.Time) error {
+	l.records = slices.DeleteFunc(l.records, func(rec sendRecord) bool {
+		return rec.sentAt.Before(t)
+	})
+	return nil
+}
+
+func TestSendLimits(t *testing.T) {
+	m, c := newTestMailer(t)
+	log := &memorySendLog{}
+	m.SetSendLimits(log,
+		SendLimit{Window: time.Hour, Recipient: 2, Domain: 3},
+		SendLimit{Window: 24 * time.Hour, Recipient: 5, Domain: 10},
+	)
+
+	sends := []struct {
+		recipient string
+		sent      bool
+	}{
+		{"jane@example.org", true},
+		{"jane@example.org", true},
+		{"jane@example.org", false}, // recipient hourly budget spent
+		{"john@EXAMPLE.org", true},
+		{"jack@example.org", false}, // domain hourly budget spent
+		{"jane@example.net", true},
+	}
+
+	for i, s := range sends {
+		c.msg.Reset()
+
+		err := m.Send(context.Background(), s.recipient, "test.tmpl", map[string]any{"token": "ABC"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if sent := c.msg.Len() > 0; sent != s.sent {
+			t.Errorf("send %d to %s: sent = %v; want %v", i, s.recipient, sent, s.sent)
+		}
+	}
+
+	if len(log.records) != 4 {
+		t.Errorf("recorded %d sends; want 4", len(log.records))
+	}
+}
+
+func TestSetSMTP(t *testing.T) {
+	// Nothing listens on a closed listener's port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().(*net.TCPAddr)
+	l.Close()
+
+	m, _ := newTestMailer(t)
+	sender := &mail.Address{Address: "no-reply@example.net"}
+
+	err = m.SetSMTP("127.0.0.1", addr.Port, "", "", sender)
+	if err == nil {
+		t.Fatal("unreachable server: got nil error")
+	}
+	if m.sender.Address == sender.Address {
+		t.Error("sender replaced after failed dial")
+	}
+
+	m.dev = true
+
+	err = m.SetSMTP("127.0.0.1", addr.Port, "", "", sender)
+	if err != nil {
+		t.Fatalf("development: %v", err)
+	}
+	if m.sender.Address != sender.Address {
+		t.Error("sender not replaced")
+	}
+}
+
+func TestPing(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, _ := newTestMailer(t)
+	m.addr = l.Addr().String()
+
+	err = m.Ping(context.Background())
+	if err != nil {
+		t.Fatalf("listening server: %v", err)
+	}
+
+	// The result is reused until pingInterval has passed
+	l.Close()
+
+	err = m.Ping(context.Background())
+	if err != nil {
+		t.Fatalf("cached result: %v", err)
+	}
+
+	m.pingedAt = time.Now().Add(-pingInterval)
+
+	err = m.Ping(context.Background())
+	if err == nil {
+		t.Fatal("closed server: got nil error")
+	}
+
+	// A cancelled probe isn't cached
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	m.pingedAt = time.Time{}
+
+	if err := m.Ping(ctx); err == nil {
+		t.Fatal("cancelled: got nil error")
+	}
+	if !m.pingedAt.IsZero() {
+		t.Error("cancelled ping was cached")
+	}
+}
+
+func TestSendObserver(t *testing.T) {
+	m, _ := newTestMailer(t)
+	m.SetSuppressionList(suppressionList{"bounced@example.org": true})
+
+	var outcomes []string
+	m.SetObserver(func(outcome string) {
+		outcomes = append(outcomes, outcome)
+	})
+
+	m.Send(context.Background(), "jane@example.org", "test.tmpl", nil)
+	m.Send(context.Background(), "bounced@example.org", "test.tmpl", nil)
+	m.Send(context.Background(), "jane@example.org", "missing.tmpl", nil)
+
+	want := []string{OutcomeSent, OutcomeSuppressed, OutcomeFailed}
+	if strings.Join(outcomes, ",") != strings.Join(want, ",") {
+		t.Errorf("got outcomes %v; want %v", outcomes, want)
+	}
+}
+
+func TestSendTrace(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+	})
+
+	// Sends are traced as part of the request that triggered them
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	defer parent.End()
+
+	m, _ := newTestMailer(t)
+	m.Send(ctx, "jane@example.org", "test.tmpl", nil)
+	m.Send(ctx, "jane@example.org", "missing.tmpl", nil)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans; want 2", len(spans))
+	}
+
+	for i, want := range []struct {
+		outcome string
+		status  codes.Code
+	}{
+		{OutcomeSent, codes.Unset},
+		{OutcomeFailed, codes.Error},
+	} {
+		span := spans[i]
+		if span.Name != "mailer.Send" {
+			t.Errorf("span %d: name = %q", i, span.Name)
+		}
+		if span.Parent.SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("span %d: not a child of the request span", i)
+		}
+		attrs := attribute.NewSet(span.Attributes...)
+		if v, _ := attrs.Value("mail.outcome"); v.AsString() != want.outcome {
+			t.Errorf("span %d: mail.outcome = %q; want %q", i, v.AsString(), want.outcome)
+		}
+		if span.Status.Code != want.status {
+			t.Errorf("span %d: status = %s; want %s", i, span.Status.Code, want.status)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Arbitrary key for the session advisory lock held while migrating,
+// so replicas starting together apply migrations one at a time.
+const lockID = 7_372_617_420_938_112_065
+
+// Applied version is tracked in the same table and format as the
+// golang-migrate CLI, so databases migrated with either are compatible.
+const schemaTable = "schema_migrations"
+
+var (
+	ErrDirty           = errors.New("migrate: database is dirty")
+	ErrNoChange        = errors.New("migrate: no change")
+	ErrMissingUp       = errors.New("migrate: missing up migration")
+	ErrVersionMismatch = errors.New("migrate: schema version mismatch")
+)
+
+var filenameRX = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version uint
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Version uint   `json:"version"`
+	Name    string `json:"name"`
+	Applied bool   `json:"applied"`
+}
+
+type Migrator struct {
+	pool       *pgxpool.Pool
+	migrations []Migration
+}
+
+// Create migrator for the SQL files in fsys, named in the
+// golang-migrate format: {version}_{name}.{up|down}.sql
+func New(pool *pgxpool.Pool, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Parse(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{pool, migrations}, nil
+}
+
+// Read migrations from fsys, sorted by version.
+func Parse(fsys fs.FS) ([]Migration, error) {
+	filenames, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[uint]*Migration{}
+	for _, fname := range filenames {
+		match := filenameRX.FindStringSubmatch(path.Base(fname))
+		if match == nil {
+			return nil, fmt.Errorf("migrate: invalid filename %q", fname)
+		}
+
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: invalid version in %q: %w", fname, err)
+		}
+
+		b, err := fs.ReadFile(fsys, fname)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[uint(version)]
+		if !ok {
+			m = &Migration{Version: uint(version), Name: match[2]}
+			byVersion[uint(version)] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migrate: conflicting names for version %d: %q and %q", version, m.Name, match[2])
+		}
+
+		switch match[3] {
+		case "up":
+			m.Up = string(b)
+		case "down":
+			m.Down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("%w: version %d", ErrMissingUp, m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Highest version of the embedded migrations.
+func (m *Migrator) Latest() uint {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Currently applied version, zero if no migrations have been applied.
+// Doesn't wait for the migration lock, a migration is only recorded
+// once its transaction commits.
+func (m *Migrator) Version(ctx context.Context) (uint, bool, error) {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	defer conn.Release()
+
+	version, dirty, err := readVersion(ctx, conn)
+	if pgErrCode(err) == pgerrcode.UndefinedTable {
+		return 0, false, nil
+	}
+
+	return version, dirty, err
+}
+
+// Report whether the applied version matches the latest migration.
+func (m *Migrator) Check(ctx context.Context) error {
+	version, dirty, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	if dirty {
+		return fmt.Errorf("%w at version %d", ErrDirty, version)
+	}
+
+	if version != m.Latest() {
+		return fmt.Errorf("%w: database is at version %d, latest migration is %d", ErrVersionMismatch, version, m.Latest())
+	}
+
+	return nil
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	version, _, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]Status, len(m.migrations))
+	for i, mig := range m.migrations {
+		status[i] = Status{
+			Version: mig.Version,
+			Name:    mig.Name,
+			Applied: mig.Version <= version,
+		}
+	}
+
+	return status, nil
+}
+
+// Apply all pending migrations, returning those applied.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		version, dirty, err := readVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("%w at version %d", ErrDirty, version)
+		}
+
+		for _, mig := range m.migrations {
+			if mig.Version <= version {
+				continue
+			}
+
+			err := apply(ctx, conn, mig.Up, &mig.Version)
+			if err != nil {
+				return fmt.Errorf("migrate: %d_%s up: %w", mig.Version, mig.Name, err)
+			}
+
+			applied = append(applied, mig)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return applied, err
+	}
+
+	if len(applied) == 0 {
+		return nil, ErrNoChange
+	}
+
+	return applied, nil
+}
+
+// Roll back the latest n applied migrations, returning those
+// reverted.
+func (m *Migrator) Down(ctx context.Context, n int) ([]Migration, error) {
+	var reverted []Migration
+
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		version, dirty, err := readVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("%w at version %d", ErrDirty, version)
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < n; i-- {
+			mig := m.migrations[i]
+			if mig.Version > version {
+				continue
+			}
+
+			// Version after rolling back, nil when none remain
+			var prev *uint
+			if i > 0 {
+				prev = &m.migrations[i-1].Version
+			}
+
+			err := apply(ctx, conn, mig.Down, prev)
+			if err != nil {
+				return fmt.Errorf("migrate: %d_%s down: %w", mig.Version, mig.Name, err)
+			}
+
+			reverted = append(reverted, mig)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return reverted, err
+	}
+
+	if len(reverted) == 0 {
+		return nil, ErrNoChange
+	}
+
+	return reverted, nil
+}
+
+// Acquire a connection holding the migration advisory lock and
+// ensure the schema table exists before calling fn.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `SELECT pg_advisory_lock($1);`, int64(lockID))
+	if err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1);`, int64(lockID))
+
+	sql := `
+		CREATE TABLE IF NOT EXISTS ` + schemaTable + ` (
+			version bigint NOT NULL PRIMARY KEY,
+			dirty boolean NOT NULL
+		);`
+
+	_, err = conn.Exec(ctx, sql)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func readVersion(ctx context.Context, conn *pgxpool.Conn) (uint, bool, error) {
+	var version int64
+	var dirty bool
+
+	sql := `SELECT version, dirty FROM ` + schemaTable + ` LIMIT 1;`
+
+	err := conn.QueryRow(ctx, sql).Scan(&version, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return 0, false, nil
+		default:
+			return 0, false, err
+		}
+	}
+
+	return uint(version), dirty, nil
+}
+
+// Run the migration SQL and record the resulting version in a single
+// transaction, so a failure leaves the database unchanged.
+func apply(ctx context.Context, conn *pgxpool.Conn, sql string, version *uint) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if sql != "" {
+		_, err = tx.Exec(ctx, sql)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM `+schemaTable+`;`)
+	if err != nil {
+		return err
+	}
+
+	if version != nil {
+		_, err = tx.Exec(ctx, `INSERT INTO `+schemaTable+` (version, dirty) VALUES ($1, false);`, int64(*version))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+func pgErrCode(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+
+	return ""
+}
@@ -0,0 +1,81 @@
+package migrate
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/micahco/api/migrations"
+)
+
+func TestParseEmbedded(t *testing.T) {
+	ms, err := Parse(migrations.Files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ms) == 0 {
+		t.Fatal("no embedded migrations")
+	}
+
+	for i, m := range ms {
+		if m.Version != uint(i+1) {
+			t.Errorf("migration %d has version %d; want sequential versions", i, m.Version)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %d_%s has no down migration", m.Version, m.Name)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	fsys := fstest.MapFS{
+		"000002_second.up.sql":   {Data: []byte("CREATE TABLE b ();")},
+		"000001_first.up.sql":    {Data: []byte("CREATE TABLE a ();")},
+		"000001_first.down.sql":  {Data: []byte("DROP TABLE a;")},
+		"000002_second.down.sql": {Data: []byte("DROP TABLE b;")},
+	}
+
+	ms, err := Parse(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ms) != 2 || ms[0].Name != "first" || ms[1].Name != "second" {
+		t.Fatalf("unexpected migrations: %+v", ms)
+	}
+	if ms[0].Up != "CREATE TABLE a ();" || ms[0].Down != "DROP TABLE a;" {
+		t.Errorf("unexpected SQL for first migration: %+v", ms[0])
+	}
+
+	m := &Migrator{migrations: ms}
+	if m.Latest() != 2 {
+		t.Errorf("latest: got %d; want 2", m.Latest())
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{"invalid filename", fstest.MapFS{"create_table.sql": {}}},
+		{"missing up", fstest.MapFS{"000001_first.down.sql": {Data: []byte("DROP TABLE a;")}}},
+		{"conflicting names", fstest.MapFS{
+			"000001_first.up.sql": {Data: []byte("CREATE TABLE a ();")},
+			"000001_other.up.sql": {Data: []byte("CREATE TABLE b ();")},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.fsys)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if tt.name == "missing up" && !errors.Is(err, ErrMissingUp) {
+				t.Errorf("got %v; want ErrMissingUp", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Memory is a token bucket per key held in process memory, so limits
+// apply to each server separately and reset on restart.
+type Memory struct {
+	mu      sync.Mutex
+	clients map[string]*client
+	maxAge  time.Duration
+}
+
+// Create store which purges keys idle for longer than maxAge.
+func NewMemory(maxAge time.Duration) *Memory {
+	return &Memory{
+		clients: make(map[string]*client),
+		maxAge:  maxAge,
+	}
+}
+
+func (m *Memory) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, found := m.clients[key]
+	if !found {
+		c = &client{
+			limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst),
+		}
+		m.clients[key] = c
+	}
+
+	now := time.Now()
+	c.lastSeen = now
+
+	// Apply changed limits to existing clients
+	if c.limiter.Limit() != rate.Limit(limit.Rate) {
+		c.limiter.SetLimit(rate.Limit(limit.Rate))
+	}
+	if c.limiter.Burst() != limit.Burst {
+		c.limiter.SetBurst(limit.Burst)
+	}
+
+	allowed := c.limiter.AllowN(now, 1)
+	tokens := c.limiter.TokensAt(now)
+
+	res := Result{
+		Allowed:   allowed,
+		Remaining: max(int(tokens), 0),
+	}
+	if !allowed {
+		// Time for the bucket to refill to one token
+		res.RetryAfter = time.Duration((1 - tokens) / limit.Rate * float64(time.Second))
+	}
+
+	return res, nil
+}
+
+func (m *Memory) Purge(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, c := range m.clients {
+		if time.Since(c.lastSeen) > m.maxAge {
+			delete(m.clients, key)
+		}
+	}
+
+	return nil
+}
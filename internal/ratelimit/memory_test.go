@@ -0,0 +1,57 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(time.Minute)
+	limit := Limit{Rate: 0.1, Burst: 2}
+
+	for i, want := range []bool{true, true, false} {
+		res, err := m.Allow(ctx, "a", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Allowed != want {
+			t.Errorf("request %d: got %t; want %t", i+1, res.Allowed, want)
+		}
+		if res.Remaining != max(1-i, 0) {
+			t.Errorf("request %d: remaining %d; want %d", i+1, res.Remaining, max(1-i, 0))
+		}
+		if !want && (res.RetryAfter <= 9*time.Second || res.RetryAfter > 10*time.Second) {
+			t.Errorf("request %d: retry after %s; want about 10s", i+1, res.RetryAfter)
+		}
+	}
+
+	// Keys are limited independently
+	if res, _ := m.Allow(ctx, "b", limit); !res.Allowed {
+		t.Error("other key was limited")
+	}
+
+	// Changed limits apply to existing keys
+	m.Allow(ctx, "a", Limit{Rate: 1, Burst: 10})
+	if l := m.clients["a"].limiter; l.Limit() != 1 || l.Burst() != 10 {
+		t.Errorf("changed limit not applied: %v, %d", l.Limit(), l.Burst())
+	}
+
+	m.clients["b"].lastSeen = time.Now().Add(-2 * time.Minute)
+	m.Purge(ctx)
+
+	if _, ok := m.clients["b"]; ok {
+		t.Error("idle key not purged")
+	}
+	if _, ok := m.clients["a"]; !ok {
+		t.Error("active key purged")
+	}
+}
+
+func TestLimitWindow(t *testing.T) {
+	got := Limit{Rate: 2, Burst: 4}.Window()
+	if got != 2*time.Second {
+		t.Errorf("got %s; want 2s", got)
+	}
+}
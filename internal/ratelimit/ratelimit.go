@@ -0,0 +1,55 @@
+// Package ratelimit decides whether a client may make another request.
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limit allows Rate requests per second on average, with bursts of
+// up to Burst requests.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Window over which a sliding window store allows Burst requests,
+// so the average is the same as a token bucket with this limit.
+func (l Limit) Window() time.Duration {
+	return time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+}
+
+// Result of a rate limit check, used for RateLimit-* headers.
+type Result struct {
+	Allowed bool
+	// Requests that would be allowed right now
+	Remaining int
+	// Time until the next request is allowed, zero if allowed
+	RetryAfter time.Duration
+}
+
+// Store keeps request counts for each client key.
+type Store interface {
+	// Record a request for key and report whether it is within limit.
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+	// Remove state for keys which haven't been seen recently.
+	Purge(ctx context.Context) error
+}
+
+// Call store.Purge every interval until ctx is done.
+func RunPurge(ctx context.Context, store Store, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := store.Purge(ctx)
+			if err != nil && ctx.Err() == nil {
+				onError(err)
+			}
+		}
+	}
+}
@@ -0,0 +1 @@
+ALTER TABLE user_ DROP COLUMN IF EXISTS admin_;
@@ -0,0 +1 @@
+ALTER TABLE user_ ADD COLUMN IF NOT EXISTS admin_ BOOLEAN NOT NULL DEFAULT false;
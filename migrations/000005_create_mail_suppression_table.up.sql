@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS mail_suppression_ (
+    email_ CITEXT PRIMARY KEY,
+    created_at_ TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    reason_ TEXT NOT NULL,
+    detail_ TEXT NOT NULL DEFAULT ''
+);
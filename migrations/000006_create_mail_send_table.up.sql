@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS mail_send_ (
+    recipient_ CITEXT NOT NULL,
+    domain_ CITEXT NOT NULL,
+    sent_at_ TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS mail_send_domain_sent_at_idx ON mail_send_ (domain_, sent_at_);
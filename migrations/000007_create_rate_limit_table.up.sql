@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS rate_limit_ (
+    key_ TEXT NOT NULL,
+    window_ BIGINT NOT NULL,
+    count_ INTEGER NOT NULL,
+    expires_at_ TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (key_, window_)
+);
+
+CREATE INDEX IF NOT EXISTS rate_limit_expires_at_idx ON rate_limit_ (expires_at_);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS ip_rule_;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS ip_rule_ (
+    id_ uuid DEFAULT gen_random_uuid() PRIMARY KEY,
+    created_at_ TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    network_ CIDR UNIQUE NOT NULL,
+    action_ TEXT NOT NULL CHECK (action_ IN ('allow', 'deny')),
+    reason_ TEXT NOT NULL DEFAULT '',
+    expires_at_ TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS ip_rule_network_idx ON ip_rule_ USING gist (network_ inet_ops);